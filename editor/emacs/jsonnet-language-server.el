@@ -10,6 +10,20 @@
   :risky t
   :type 'file)
 
+(defcustom lsp-jsonnet-format-on-save nil
+  "Format Jsonnet files before they are saved."
+  :group 'lsp-jsonnet
+  :type 'boolean)
+
+(defcustom lsp-jsonnet-inlay-hint-values nil
+  "Show the evaluated value of constant locals as inlay hints."
+  :group 'lsp-jsonnet
+  :type 'boolean)
+
+(lsp-register-custom-settings
+ '(("format_on_save" lsp-jsonnet-format-on-save t)
+   ("inlay_hint_values" lsp-jsonnet-inlay-hint-values t)))
+
 ;; Configure lsp-mode language identifiers.
 (add-to-list 'lsp-language-id-configuration '(jsonnet-mode . "jsonnet"))
 
@@ -18,6 +32,11 @@
  (make-lsp-client
   :new-connection (lsp-stdio-connection (lambda () lsp-jsonnet-executable))
   :activation-fn (lsp-activate-on "jsonnet")
+  :initialized-fn (lambda (workspace)
+                    (with-lsp-workspace workspace
+                      (lsp--set-configuration
+                       (ht-merge (lsp-configuration-section "format_on_save")
+                                 (lsp-configuration-section "inlay_hint_values")))))
   :server-id 'jsonnet))
 
 ;; Start the language server whenever jsonnet-mode is used.
@@ -58,6 +58,8 @@ Options:
   --lint             Enable linting.
   -v / --version     Print version.
 
+These options can also be set by the client, see the settings in the README.
+
 Environment variables:
   JSONNET_PATH is a %[2]q separated list of directories
   added in reverse order before the paths specified by --jpath
@@ -117,7 +119,7 @@ func main() {
 	s.EvalDiags = evalDiags
 
 	conn.Go(ctx, protocol.Handlers(
-		protocol.ServerHandler(s, jsonrpc2.MethodNotFound)))
+		s.InlayHintCapabilitiesHandler(server.UnresolvedCodeLensHandler(protocol.ServerHandler(s, jsonrpc2.MethodNotFound)))))
 	<-conn.Done()
 	if err := conn.Err(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
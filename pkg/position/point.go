@@ -11,3 +11,12 @@ func PositionProtocolToAST(point protocol.Position) ast.Location {
 		Column: int(point.Character) + 1,
 	}
 }
+
+// PositionASTToProtocol translates a ast.Location to a protocol.Position.
+// The former is one indexed and the latter is zero indexed.
+func PositionASTToProtocol(location ast.Location) protocol.Position {
+	return protocol.Position{
+		Line:      uint32(location.Line - 1),
+		Character: uint32(location.Column - 1),
+	}
+}
@@ -39,9 +39,10 @@ type document struct {
 // newCache returns a document cache.
 func newCache() *cache {
 	return &cache{
-		mu:        sync.RWMutex{},
-		docs:      make(map[protocol.DocumentURI]*document),
-		diagQueue: make(map[protocol.DocumentURI]struct{}),
+		mu:             sync.RWMutex{},
+		docs:           make(map[protocol.DocumentURI]*document),
+		semanticTokens: make(map[protocol.DocumentURI]*protocol.SemanticTokens),
+		diagQueue:      make(map[protocol.DocumentURI]struct{}),
 	}
 }
 
@@ -49,6 +50,8 @@ func newCache() *cache {
 type cache struct {
 	mu   sync.RWMutex
 	docs map[protocol.DocumentURI]*document
+	// semanticTokens holds the last tokens sent for each document by SemanticTokensFull, to compute deltas
+	semanticTokens map[protocol.DocumentURI]*protocol.SemanticTokens
 
 	diagMutex   sync.RWMutex
 	diagQueue   map[protocol.DocumentURI]struct{}
@@ -85,3 +88,31 @@ func (c *cache) get(uri protocol.DocumentURI) (*document, error) {
 
 	return doc, nil
 }
+
+// documents returns all the documents in the cache.
+func (c *cache) documents() []*document {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	docs := make([]*document, 0, len(c.docs))
+	for _, doc := range c.docs {
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+// getSemanticTokens retrieves the last semantic tokens sent for a document, nil if there are none.
+func (c *cache) getSemanticTokens(uri protocol.DocumentURI) *protocol.SemanticTokens {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.semanticTokens[uri]
+}
+
+// putSemanticTokens stores the semantic tokens sent for a document.
+func (c *cache) putSemanticTokens(uri protocol.DocumentURI, tokens *protocol.SemanticTokens) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.semanticTokens[uri] = tokens
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// functionDefinition is a function-valued local or field.
+// Calls made outside of any function are attributed to a definition of the whole file, without function.
+type functionDefinition struct {
+	name           string
+	kind           protocol.SymbolKind
+	function       *ast.Function
+	fullRange      ast.LocationRange
+	selectionRange ast.LocationRange
+}
+
+// functionCall is a call whose target has a location, with the definition it is made from.
+type functionCall struct {
+	target ast.Node
+	caller *functionDefinition
+}
+
+func (s *server) PrepareCallHierarchy(ctx context.Context, params *protocol.CallHierarchyPrepareParams) ([]protocol.CallHierarchyItem, error) {
+	doc, err := s.cache.get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("PrepareCallHierarchy: %s: %w", errorRetrievingDocument, err)
+	}
+
+	if doc.ast == nil {
+		return nil, utils.LogErrorf("PrepareCallHierarchy: error parsing the document")
+	}
+
+	filename, err := filepath.Abs(doc.item.URI.SpanURI().Filename())
+	if err != nil {
+		return nil, utils.LogErrorf("PrepareCallHierarchy: %w", err)
+	}
+
+	// The cursor is on the name of a definition
+	location := position.PositionProtocolToAST(params.Position)
+	for _, definition := range findFunctionDefinitions(doc.ast) {
+		if position.InRange(location, definition.selectionRange) {
+			return []protocol.CallHierarchyItem{definition.item(filename)}, nil
+		}
+	}
+
+	// The cursor is on a reference, the definition may be in another file
+	vm, err := s.getVM(filename)
+	if err != nil {
+		return nil, utils.LogErrorf("PrepareCallHierarchy: error creating the VM: %w", err)
+	}
+	links, err := findDefinition(doc.ast, &protocol.DefinitionParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: params.TextDocument,
+			Position:     params.Position,
+		},
+	}, vm)
+	if err != nil {
+		log.Debugf("PrepareCallHierarchy: no definition found: %v", err)
+		return nil, nil
+	}
+
+	var items []protocol.CallHierarchyItem
+	for _, link := range links {
+		if definition := s.findFunctionDefinitionAt(link.TargetURI, link.TargetSelectionRange); definition != nil {
+			items = append(items, definition.item(link.TargetURI.SpanURI().Filename()))
+		}
+	}
+	return items, nil
+}
+
+func (s *server) IncomingCalls(ctx context.Context, params *protocol.CallHierarchyIncomingCallsParams) ([]protocol.CallHierarchyIncomingCall, error) {
+	var calls []protocol.CallHierarchyIncomingCall
+	for _, filename := range s.callHierarchyFiles(params.Item.URI.SpanURI().Filename()) {
+		root, err := s.loadAST(filename)
+		if err != nil {
+			log.Debugf("IncomingCalls: skipping %s: %v", filename, err)
+			continue
+		}
+		vm, err := s.getVM(filename)
+		if err != nil {
+			return nil, utils.LogErrorf("IncomingCalls: error creating the VM: %w", err)
+		}
+
+		callsByCaller := map[*functionDefinition][]protocol.Range{}
+		var callers []*functionDefinition
+		for _, call := range findFunctionCalls(root, filename) {
+			for _, link := range resolveReference(root, filename, call.target, vm) {
+				if link.TargetURI.SpanURI().Filename() != params.Item.URI.SpanURI().Filename() || link.TargetSelectionRange != params.Item.SelectionRange {
+					continue
+				}
+				if _, ok := callsByCaller[call.caller]; !ok {
+					callers = append(callers, call.caller)
+				}
+				callsByCaller[call.caller] = append(callsByCaller[call.caller], position.RangeASTToProtocol(*call.target.Loc()))
+			}
+		}
+		for _, caller := range callers {
+			calls = append(calls, protocol.CallHierarchyIncomingCall{
+				From:       caller.item(filename),
+				FromRanges: callsByCaller[caller],
+			})
+		}
+	}
+	return calls, nil
+}
+
+func (s *server) OutgoingCalls(ctx context.Context, params *protocol.CallHierarchyOutgoingCallsParams) ([]protocol.CallHierarchyOutgoingCall, error) {
+	filename := params.Item.URI.SpanURI().Filename()
+	root, err := s.loadAST(filename)
+	if err != nil {
+		return nil, utils.LogErrorf("OutgoingCalls: %w", err)
+	}
+	vm, err := s.getVM(filename)
+	if err != nil {
+		return nil, utils.LogErrorf("OutgoingCalls: error creating the VM: %w", err)
+	}
+
+	var (
+		calls       []protocol.CallHierarchyOutgoingCall
+		callIndexes = map[protocol.Location]int{}
+	)
+	for _, call := range findFunctionCalls(root, filename) {
+		if position.RangeASTToProtocol(call.caller.selectionRange) != params.Item.SelectionRange {
+			continue
+		}
+		for _, link := range resolveReference(root, filename, call.target, vm) {
+			key := protocol.Location{URI: link.TargetURI, Range: link.TargetSelectionRange}
+			if i, ok := callIndexes[key]; ok {
+				calls[i].FromRanges = append(calls[i].FromRanges, position.RangeASTToProtocol(*call.target.Loc()))
+				continue
+			}
+			definition := s.findFunctionDefinitionAt(link.TargetURI, link.TargetSelectionRange)
+			if definition == nil {
+				continue
+			}
+			callIndexes[key] = len(calls)
+			calls = append(calls, protocol.CallHierarchyOutgoingCall{
+				To:         definition.item(link.TargetURI.SpanURI().Filename()),
+				FromRanges: []protocol.Range{position.RangeASTToProtocol(*call.target.Loc())},
+			})
+		}
+	}
+	return calls, nil
+}
+
+func (d *functionDefinition) item(filename string) protocol.CallHierarchyItem {
+	item := protocol.CallHierarchyItem{
+		Name:           d.name,
+		Kind:           d.kind,
+		URI:            protocol.URIFromPath(filename),
+		Range:          position.RangeASTToProtocol(d.fullRange),
+		SelectionRange: position.RangeASTToProtocol(d.selectionRange),
+	}
+	if d.function != nil {
+		item.Detail = fmt.Sprintf("%s(%s)", d.name, strings.Join(functionParamNames(d.function), ", "))
+	}
+	return item
+}
+
+// findFunctionDefinitions returns the function-valued locals and fields of a document.
+func findFunctionDefinitions(root ast.Node) []*functionDefinition {
+	var definitions []*functionDefinition
+	addBinds := func(binds ast.LocalBinds) {
+		for _, bind := range binds {
+			function, ok := bind.Body.(*ast.Function)
+			if !ok {
+				continue
+			}
+			fullRange := bind.LocRange
+			if !fullRange.Begin.IsSet() {
+				fullRange = *bind.Body.Loc()
+			}
+			begin := bindNameLocation(bind)
+			definitions = append(definitions, &functionDefinition{
+				name:           string(bind.Variable),
+				kind:           protocol.Function,
+				function:       function,
+				fullRange:      fullRange,
+				selectionRange: ast.LocationRange{Begin: begin, End: ast.Location{Line: begin.Line, Column: begin.Column + len(bind.Variable)}},
+			})
+		}
+	}
+
+	walkNodes(root, func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.Local:
+			addBinds(node.Binds)
+		case *ast.DesugaredObject:
+			addBinds(node.Locals)
+			for _, field := range node.Fields {
+				function, ok := field.Body.(*ast.Function)
+				name, isString := field.Name.(*ast.LiteralString)
+				if !ok || !isString || !field.LocRange.Begin.IsSet() {
+					continue
+				}
+				begin := field.LocRange.Begin
+				definitions = append(definitions, &functionDefinition{
+					name:           name.Value,
+					kind:           protocol.Method,
+					function:       function,
+					fullRange:      field.LocRange,
+					selectionRange: ast.LocationRange{Begin: begin, End: ast.Location{Line: begin.Line, Column: begin.Column + len(name.Value)}},
+				})
+			}
+		}
+	})
+	return definitions
+}
+
+// findFunctionCalls returns the calls of a document, each attributed to the innermost definition containing it.
+func findFunctionCalls(root ast.Node, filename string) []functionCall {
+	definitions := findFunctionDefinitions(root)
+	file := &functionDefinition{
+		name:           filepath.Base(filename),
+		kind:           protocol.File,
+		fullRange:      *root.Loc(),
+		selectionRange: ast.LocationRange{Begin: ast.Location{Line: 1, Column: 1}, End: ast.Location{Line: 1, Column: 1}},
+	}
+
+	var calls []functionCall
+	walkNodes(root, func(node ast.Node) {
+		apply, ok := node.(*ast.Apply)
+		if !ok || !apply.Target.Loc().IsSet() {
+			return
+		}
+		caller := file
+		for _, definition := range definitions {
+			if position.InRange(apply.Target.Loc().Begin, definition.fullRange) && position.RangeGreaterOrEqual(caller.fullRange, definition.fullRange) {
+				caller = definition
+			}
+		}
+		calls = append(calls, functionCall{target: apply.Target, caller: caller})
+	})
+	return calls
+}
+
+// resolveReference finds the definitions of a variable or an index, e.g. the target of a call.
+// Variables are resolved from their start, indexes from the last character of their name.
+func resolveReference(root ast.Node, filename string, target ast.Node, vm *jsonnet.VM) []protocol.DefinitionLink {
+	location := target.Loc().Begin
+	if _, ok := target.(*ast.Index); ok {
+		location = ast.Location{Line: target.Loc().End.Line, Column: target.Loc().End.Column - 1}
+	}
+	links, err := findDefinition(root, &protocol.DefinitionParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: protocol.URIFromPath(filename)},
+			Position:     position.PositionASTToProtocol(location),
+		},
+	}, vm)
+	if err != nil {
+		log.Debugf("unable to resolve the call at %v: %v", location, err)
+		return nil
+	}
+	return links
+}
+
+// findFunctionDefinitionAt returns the function definition whose name is at the given range.
+func (s *server) findFunctionDefinitionAt(uri protocol.DocumentURI, selectionRange protocol.Range) *functionDefinition {
+	root, err := s.loadAST(uri.SpanURI().Filename())
+	if err != nil {
+		log.Debugf("unable to load %s: %v", uri, err)
+		return nil
+	}
+	for _, definition := range findFunctionDefinitions(root) {
+		if position.RangeASTToProtocol(definition.selectionRange) == selectionRange {
+			return definition
+		}
+	}
+	return nil
+}
+
+// callHierarchyFiles returns the files that may call a function of the given file:
+// the file itself, the open documents and the files they import, transitively.
+func (s *server) callHierarchyFiles(filename string) []string {
+	queue := []string{filename}
+	for _, doc := range s.cache.documents() {
+		queue = append(queue, doc.item.URI.SpanURI().Filename())
+	}
+
+	var files []string
+	seen := map[string]bool{}
+	for len(queue) > 0 {
+		current, err := filepath.Abs(queue[0])
+		queue = queue[1:]
+		if err != nil || seen[current] {
+			continue
+		}
+		seen[current] = true
+		files = append(files, current)
+
+		root, err := s.loadAST(current)
+		if err != nil {
+			continue
+		}
+		vm, err := s.getVM(current)
+		if err != nil {
+			continue
+		}
+		walkNodes(root, func(node ast.Node) {
+			if importNode, ok := node.(*ast.Import); ok {
+				if foundAt, err := vm.ResolveImport(current, importNode.File.Value); err == nil {
+					queue = append(queue, foundAt)
+				}
+			}
+		})
+	}
+	return files
+}
+
+// loadAST returns the AST of an open document or parses the file from disk.
+func (s *server) loadAST(filename string) (ast.Node, error) {
+	root, _, err := s.loadDocument(filename)
+	return root, err
+}
+
+// loadDocument returns the AST and the text of an open document or reads them from disk.
+func (s *server) loadDocument(filename string) (ast.Node, string, error) {
+	for _, doc := range s.cache.documents() {
+		docFilename, err := filepath.Abs(doc.item.URI.SpanURI().Filename())
+		if err == nil && docFilename == filename && doc.ast != nil {
+			return doc.ast, doc.item.Text, nil
+		}
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	root, err := jsonnet.SnippetToAST(filename, string(content))
+	return root, string(content), err
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallHierarchy(t *testing.T) {
+	s := testServer(t, nil)
+	uri := serverOpenTestFile(t, s, "./testdata/call-hierarchy.jsonnet")
+	mainURI := absUri(t, "./testdata/call-hierarchy.jsonnet")
+	libURI := absUri(t, "./testdata/call-hierarchy-lib.libsonnet")
+
+	deployment := protocol.CallHierarchyItem{
+		Name:           "deployment",
+		Kind:           protocol.Function,
+		Detail:         "deployment(name)",
+		URI:            mainURI,
+		Range:          position.NewProtocolRange(1, 6, 1, 74),
+		SelectionRange: position.NewProtocolRange(1, 6, 1, 16),
+	}
+	service := protocol.CallHierarchyItem{
+		Name:           "service",
+		Kind:           protocol.Function,
+		Detail:         "service(name)",
+		URI:            mainURI,
+		Range:          position.NewProtocolRange(2, 6, 2, 35),
+		SelectionRange: position.NewProtocolRange(2, 6, 2, 13),
+	}
+	file := protocol.CallHierarchyItem{
+		Name:           "call-hierarchy.jsonnet",
+		Kind:           protocol.File,
+		URI:            mainURI,
+		Range:          position.NewProtocolRange(0, 0, 6, 1),
+		SelectionRange: position.NewProtocolRange(0, 0, 0, 0),
+	}
+	libNew := protocol.CallHierarchyItem{
+		Name:           "new",
+		Kind:           protocol.Method,
+		Detail:         "new(name)",
+		URI:            libURI,
+		Range:          position.NewProtocolRange(1, 2, 4, 3),
+		SelectionRange: position.NewProtocolRange(1, 2, 1, 5),
+	}
+	libWithLabels := protocol.CallHierarchyItem{
+		Name:           "withLabels",
+		Kind:           protocol.Method,
+		Detail:         "withLabels(name)",
+		URI:            libURI,
+		Range:          position.NewProtocolRange(5, 2, 5, 34),
+		SelectionRange: position.NewProtocolRange(5, 2, 5, 12),
+	}
+
+	prepare := func(line, character uint32) []protocol.CallHierarchyItem {
+		items, err := s.PrepareCallHierarchy(context.Background(), &protocol.CallHierarchyPrepareParams{
+			TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+				Position:     protocol.Position{Line: line, Character: character},
+			},
+		})
+		require.NoError(t, err)
+		return items
+	}
+
+	t.Run("prepare on a definition", func(t *testing.T) {
+		assert.Equal(t, []protocol.CallHierarchyItem{deployment}, prepare(1, 8))
+	})
+
+	t.Run("prepare on a call", func(t *testing.T) {
+		assert.Equal(t, []protocol.CallHierarchyItem{deployment}, prepare(4, 8))
+	})
+
+	t.Run("prepare on a call to an imported function", func(t *testing.T) {
+		assert.Equal(t, []protocol.CallHierarchyItem{libNew}, prepare(1, 30))
+	})
+
+	t.Run("prepare on something else", func(t *testing.T) {
+		assert.Empty(t, prepare(0, 3))
+	})
+
+	t.Run("incoming calls from the top level", func(t *testing.T) {
+		calls, err := s.IncomingCalls(context.Background(), &protocol.CallHierarchyIncomingCallsParams{Item: deployment})
+		require.NoError(t, err)
+		assert.Equal(t, []protocol.CallHierarchyIncomingCall{
+			{From: file, FromRanges: []protocol.Range{position.NewProtocolRange(4, 7, 4, 17)}},
+		}, calls)
+	})
+
+	t.Run("incoming calls from functions", func(t *testing.T) {
+		calls, err := s.IncomingCalls(context.Background(), &protocol.CallHierarchyIncomingCallsParams{Item: libNew})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []protocol.CallHierarchyIncomingCall{
+			{From: deployment, FromRanges: []protocol.Range{position.NewProtocolRange(1, 25, 1, 32)}},
+			{From: service, FromRanges: []protocol.Range{position.NewProtocolRange(2, 22, 2, 29)}},
+		}, calls)
+	})
+
+	t.Run("incoming calls from another file", func(t *testing.T) {
+		calls, err := s.IncomingCalls(context.Background(), &protocol.CallHierarchyIncomingCallsParams{Item: libWithLabels})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []protocol.CallHierarchyIncomingCall{
+			{From: libNew, FromRanges: []protocol.Range{position.NewProtocolRange(3, 12, 3, 24)}},
+			{From: deployment, FromRanges: []protocol.Range{position.NewProtocolRange(1, 52, 1, 66)}},
+		}, calls)
+	})
+
+	t.Run("outgoing calls to another file", func(t *testing.T) {
+		calls, err := s.OutgoingCalls(context.Background(), &protocol.CallHierarchyOutgoingCallsParams{Item: deployment})
+		require.NoError(t, err)
+		assert.Equal(t, []protocol.CallHierarchyOutgoingCall{
+			{To: libNew, FromRanges: []protocol.Range{position.NewProtocolRange(1, 25, 1, 32)}},
+			{To: libWithLabels, FromRanges: []protocol.Range{position.NewProtocolRange(1, 52, 1, 66)}},
+		}, calls)
+	})
+
+	t.Run("outgoing calls within a file", func(t *testing.T) {
+		calls, err := s.OutgoingCalls(context.Background(), &protocol.CallHierarchyOutgoingCallsParams{Item: libNew})
+		require.NoError(t, err)
+		assert.Equal(t, []protocol.CallHierarchyOutgoingCall{
+			{To: libWithLabels, FromRanges: []protocol.Range{position.NewProtocolRange(3, 12, 3, 24)}},
+		}, calls)
+	})
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/jsonrpc2"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// codeLensData identifies the definition that an unresolved reference count lens is shown above.
+type codeLensData struct {
+	URI            protocol.DocumentURI `json:"uri"`
+	Name           string               `json:"name"`
+	SelectionRange protocol.Range       `json:"selectionRange"`
+}
+
+func (s *server) CodeLens(ctx context.Context, params *protocol.CodeLensParams) ([]protocol.CodeLens, error) {
+	doc, err := s.cache.get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("CodeLens: %s: %w", errorRetrievingDocument, err)
+	}
+
+	if doc.ast == nil {
+		// Code lenses are requested on every change. Throwing an error on each request is noisy
+		log.Error("CodeLens: error parsing the document")
+		return nil, nil
+	}
+
+	filename, err := filepath.Abs(doc.item.URI.SpanURI().Filename())
+	if err != nil {
+		return nil, utils.LogErrorf("CodeLens: %w", err)
+	}
+
+	var lenses []protocol.CodeLens
+	if isTankaEnvironment(doc.ast, filename) {
+		fileArg, _ := json.Marshal(filename)
+		lenses = append(lenses,
+			protocol.CodeLens{Command: protocol.Command{Title: "Evaluate", Command: "jsonnet.evalFile", Arguments: []json.RawMessage{fileArg}}},
+			protocol.CodeLens{Command: protocol.Command{Title: "Show manifests", Command: "tanka.showManifests", Arguments: []json.RawMessage{fileArg}}},
+		)
+	}
+
+	// Reference counts are computed when the lenses are resolved
+	for _, definition := range findTopLevelDefinitions(doc.ast) {
+		selectionRange := position.RangeASTToProtocol(definition.selectionRange)
+		lenses = append(lenses, protocol.CodeLens{
+			Range: selectionRange,
+			Data:  codeLensData{URI: protocol.URIFromPath(filename), Name: definition.name, SelectionRange: selectionRange},
+		})
+	}
+	return lenses, nil
+}
+
+func (s *server) ResolveCodeLens(ctx context.Context, lens *protocol.CodeLens) (*protocol.CodeLens, error) {
+	var data codeLensData
+	if err := decodeParams(lens.Data, &data); err != nil {
+		return nil, err
+	}
+
+	count := s.countReferences(data)
+	title := fmt.Sprintf("%d references", count)
+	if count == 1 {
+		title = "1 reference"
+	}
+	lens.Command = protocol.Command{Title: title}
+	return lens, nil
+}
+
+// countReferences counts the uses of a definition in the files that may reference it (see callHierarchyFiles).
+func (s *server) countReferences(data codeLensData) int {
+	count := 0
+	for _, filename := range s.callHierarchyFiles(data.URI.SpanURI().Filename()) {
+		root, text, err := s.loadDocument(filename)
+		if err != nil {
+			log.Debugf("countReferences: skipping %s: %v", filename, err)
+			continue
+		}
+		vm, err := s.getVM(filename)
+		if err != nil {
+			log.Errorf("countReferences: error creating the VM: %v", err)
+			return count
+		}
+
+		lines := strings.Split(text, "\n")
+		walkNodes(root, func(node ast.Node) {
+			if _, ok := symbolUseRange(node, data.Name, lines); !ok {
+				return
+			}
+			for _, link := range resolveReference(root, filename, node, vm) {
+				if link.TargetURI.SpanURI().Filename() == data.URI.SpanURI().Filename() && link.TargetSelectionRange == data.SelectionRange {
+					count++
+					break
+				}
+			}
+		})
+	}
+	return count
+}
+
+// topLevelDefinition is a local or a field of the top-level object of a file.
+type topLevelDefinition struct {
+	name           string
+	selectionRange ast.LocationRange
+}
+
+// findTopLevelDefinitions returns the locals and fields of the top-level object of a file, including the locals defined before it.
+func findTopLevelDefinitions(root ast.Node) []topLevelDefinition {
+	var definitions []topLevelDefinition
+	addBinds := func(binds ast.LocalBinds) {
+		for _, bind := range binds {
+			begin := bindNameLocation(bind)
+			if !begin.IsSet() {
+				continue
+			}
+			definitions = append(definitions, topLevelDefinition{
+				name:           string(bind.Variable),
+				selectionRange: ast.LocationRange{Begin: begin, End: ast.Location{Line: begin.Line, Column: begin.Column + len(bind.Variable)}},
+			})
+		}
+	}
+
+	node := root
+	for {
+		switch current := node.(type) {
+		case *ast.Local:
+			addBinds(current.Binds)
+			node = current.Body
+			continue
+		case *ast.DesugaredObject:
+			addBinds(current.Locals)
+			for _, field := range current.Fields {
+				name, ok := field.Name.(*ast.LiteralString)
+				if !ok || !field.LocRange.Begin.IsSet() {
+					continue
+				}
+				begin := field.LocRange.Begin
+				definitions = append(definitions, topLevelDefinition{
+					name:           name.Value,
+					selectionRange: ast.LocationRange{Begin: begin, End: ast.Location{Line: begin.Line, Column: begin.Column + len(name.Value)}},
+				})
+			}
+		}
+		return definitions
+	}
+}
+
+// isTankaEnvironment checks if the file is the entrypoint of a Tanka environment: a main.jsonnet file next to
+// a spec.json, or one declaring inline environments.
+func isTankaEnvironment(root ast.Node, filename string) bool {
+	if filepath.Base(filename) != "main.jsonnet" {
+		return false
+	}
+	return findTankaEnvironment(filename) != nil || declaresInlineEnvironment(root)
+}
+
+// declaresInlineEnvironment checks if a file contains an object with the API version of Tanka environments.
+// Inline environments are only listed by evaluating the file, which is too slow for code lenses.
+func declaresInlineEnvironment(root ast.Node) bool {
+	found := false
+	walkNodes(root, func(node ast.Node) {
+		object, ok := node.(*ast.DesugaredObject)
+		if !ok {
+			return
+		}
+		for _, field := range object.Fields {
+			name, ok := field.Name.(*ast.LiteralString)
+			if !ok || name.Value != "apiVersion" {
+				continue
+			}
+			if value, ok := field.Body.(*ast.LiteralString); ok && strings.HasPrefix(value.Value, "tanka.dev/") {
+				found = true
+			}
+		}
+	})
+	return found
+}
+
+// codeLens mirrors protocol.CodeLens with an optional command.
+// The protocol library always serializes the command, which clients take as already resolved.
+type codeLens struct {
+	Range   protocol.Range    `json:"range"`
+	Command *protocol.Command `json:"command,omitempty"`
+	Data    interface{}       `json:"data,omitempty"`
+}
+
+// UnresolvedCodeLensHandler omits the empty commands of code lenses, so that clients resolve them.
+func UnresolvedCodeLensHandler(handler jsonrpc2.Handler) jsonrpc2.Handler {
+	return func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		if req.Method() != "textDocument/codeLens" {
+			return handler(ctx, reply, req)
+		}
+		return handler(ctx, func(ctx context.Context, result interface{}, err error) error {
+			if lenses, ok := result.([]protocol.CodeLens); ok {
+				converted := make([]codeLens, 0, len(lenses))
+				for _, lens := range lenses {
+					c := codeLens{Range: lens.Range, Data: lens.Data}
+					if lens.Command.Title != "" || lens.Command.Command != "" {
+						command := lens.Command
+						c.Command = &command
+					}
+					converted = append(converted, c)
+				}
+				result = converted
+			}
+			return reply(ctx, result, err)
+		}, req)
+	}
+}
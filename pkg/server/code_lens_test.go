@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/jdbaldry/go-language-server-protocol/jsonrpc2"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodeLens(t *testing.T) {
+	s := testServer(t, nil)
+	uri := serverOpenTestFile(t, s, "./testdata/call-hierarchy.jsonnet")
+
+	lenses, err := s.CodeLens(context.Background(), &protocol.CodeLensParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	require.NoError(t, err)
+
+	titles := map[string]string{}
+	for _, lens := range lenses {
+		assert.Empty(t, lens.Command, "lenses are resolved lazily")
+
+		// Lenses are sent back as generic JSON to be resolved
+		raw, err := json.Marshal(lens)
+		require.NoError(t, err)
+		var unresolved protocol.CodeLens
+		require.NoError(t, json.Unmarshal(raw, &unresolved))
+
+		resolved, err := s.ResolveCodeLens(context.Background(), &unresolved)
+		require.NoError(t, err)
+		assert.Equal(t, lens.Range, resolved.Range)
+		titles[lens.Data.(codeLensData).Name] = resolved.Command.Title
+	}
+	assert.Equal(t, map[string]string{
+		"lib":        "3 references",
+		"deployment": "1 reference",
+		"service":    "1 reference",
+		"app":        "0 references",
+		"svc":        "0 references",
+	}, titles)
+}
+
+func TestCodeLensImportedFields(t *testing.T) {
+	s := testServer(t, nil)
+	serverOpenTestFile(t, s, "./testdata/call-hierarchy.jsonnet")
+	libURI := absUri(t, "./testdata/call-hierarchy-lib.libsonnet")
+
+	for _, tc := range []struct {
+		name     string
+		rng      protocol.Range
+		expected string
+	}{
+		{name: "new", rng: position.NewProtocolRange(1, 2, 1, 5), expected: "2 references"},
+		{name: "withLabels", rng: position.NewProtocolRange(5, 2, 5, 12), expected: "2 references"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resolved, err := s.ResolveCodeLens(context.Background(), &protocol.CodeLens{
+				Range: tc.rng,
+				Data:  codeLensData{URI: libURI, Name: tc.name, SelectionRange: tc.rng},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, resolved.Command.Title)
+		})
+	}
+}
+
+func TestCodeLensTankaEnvironment(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		file       string
+		withLenses bool
+	}{
+		{name: "environment with a spec.json", file: "./testdata/tanka/environments/default/main.jsonnet", withLenses: true},
+		{name: "inline environments", file: "./testdata/tanka/environments/inline/main.jsonnet", withLenses: true},
+		{name: "jsonnet-bundler project", file: "./testdata/bundler/main.jsonnet"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := testServer(t, nil)
+			uri := serverOpenTestFile(t, s, tc.file)
+
+			lenses, err := s.CodeLens(context.Background(), &protocol.CodeLensParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			})
+			require.NoError(t, err)
+
+			var commands []protocol.CodeLens
+			for _, lens := range lenses {
+				if lens.Command.Command != "" {
+					commands = append(commands, lens)
+				}
+			}
+			if !tc.withLenses {
+				assert.Empty(t, commands)
+				return
+			}
+
+			filename, err := filepath.Abs(tc.file)
+			require.NoError(t, err)
+			fileArg, err := json.Marshal(filename)
+			require.NoError(t, err)
+			assert.Equal(t, []protocol.CodeLens{
+				{Command: protocol.Command{Title: "Evaluate", Command: "jsonnet.evalFile", Arguments: []json.RawMessage{fileArg}}},
+				{Command: protocol.Command{Title: "Show manifests", Command: "tanka.showManifests", Arguments: []json.RawMessage{fileArg}}},
+			}, commands)
+		})
+	}
+}
+
+func TestUnresolvedCodeLensHandler(t *testing.T) {
+	handler := UnresolvedCodeLensHandler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		return reply(ctx, []protocol.CodeLens{
+			{Range: position.NewProtocolRange(0, 0, 0, 1), Data: "unresolved"},
+			{Command: protocol.Command{Title: "Evaluate", Command: "jsonnet.evalFile"}},
+		}, nil)
+	})
+
+	var result interface{}
+	reply := func(ctx context.Context, r interface{}, err error) error {
+		result = r
+		return err
+	}
+	call, err := jsonrpc2.NewCall(jsonrpc2.NewIntID(1), "textDocument/codeLens", nil)
+	require.NoError(t, err)
+	require.NoError(t, handler(context.Background(), reply, call))
+
+	raw, err := json.Marshal(result)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"range": {"start": {"line": 0, "character": 0}, "end": {"line": 0, "character": 1}}, "data": "unresolved"},
+		{"range": {"start": {"line": 0, "character": 0}, "end": {"line": 0, "character": 0}}, "command": {"title": "Evaluate", "command": "jsonnet.evalFile"}}
+	]`, string(raw))
+}
@@ -22,6 +22,9 @@ func (s *server) Completion(ctx context.Context, params *protocol.CompletionPara
 		charIndex = len(line)
 	}
 	line = line[:charIndex]
+	if nativeItems, ok := s.nativeFunctionCompletion(doc.item.URI.SpanURI().Filename(), line); ok {
+		return &protocol.CompletionList{IsIncomplete: false, Items: nativeItems}, nil
+	}
 	stdIndex := strings.LastIndex(line, "std.")
 	if stdIndex != -1 {
 		userInput := line[stdIndex+4:]
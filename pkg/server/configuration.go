@@ -3,54 +3,578 @@ package server
 import (
 	"context"
 	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/gobwas/glob"
 	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/formatter"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
 	"github.com/jdbaldry/go-language-server-protocol/jsonrpc2"
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
 )
 
+// configurationSection is the section of the settings pulled with `workspace/configuration` requests.
+const configurationSection = "jsonnet"
+
 func (s *server) DidChangeConfiguration(ctx context.Context, params *protocol.DidChangeConfigurationParams) error {
-	settingsMap, ok := params.Settings.(map[string]interface{})
+	// Clients using the pull model send empty notifications, the settings are requested instead
+	if settingsMap, ok := params.Settings.(map[string]interface{}); s.configurationPull && (params.Settings == nil || ok && len(settingsMap) == 0) {
+		return s.pullConfiguration(ctx)
+	}
+	return s.changeConfiguration(ctx, params.Settings)
+}
+
+// pullConfiguration requests the settings from the client and applies them.
+// Settings of workspace folders are requested for their scope, they apply over the global settings.
+func (s *server) pullConfiguration(ctx context.Context) error {
+	folders := s.workspaceFolders()
+	items := []protocol.ConfigurationItem{{Section: configurationSection}}
+	for _, folder := range folders {
+		items = append(items, protocol.ConfigurationItem{ScopeURI: folder.uri, Section: configurationSection})
+	}
+
+	result, err := s.client.Configuration(ctx, &protocol.ParamConfiguration{
+		ConfigurationParams: protocol.ConfigurationParams{Items: items},
+	})
+	if err != nil {
+		return utils.LogErrorf("pullConfiguration: %w", err)
+	}
+	if len(result) > 0 && result[0] != nil {
+		if err := s.changeConfiguration(ctx, result[0]); err != nil {
+			return err
+		}
+	}
+	for i, folder := range folders {
+		var settings interface{}
+		if i+1 < len(result) {
+			settings = result[i+1]
+		}
+		s.changeFolderConfiguration(ctx, folder, settings)
+	}
+	return nil
+}
+
+// folderSettingsSchema maps the keys of the settings that can differ between workspace folders to the function
+// validating and applying their value. Relative paths are resolved from root.
+var folderSettingsSchema = map[string]func(f *folderSettings, root, key string, value interface{}) error{
+	"ext_vars":                setEvalVars,
+	"ext_code":                setEvalVars,
+	"tla_str":                 setEvalVars,
+	"tla_code":                setEvalVars,
+	"overrides":               setEvalOverrides,
+	"jpath":                   setJPath,
+	"tanka_mode":              boolSetting(func(f *folderSettings) *bool { return &f.tankaMode }),
+	"tanka_natives":           boolSetting(func(f *folderSettings) *bool { return &f.tankaNatives }),
+	"native_functions":        setNativeFunctions,
+	"enable_lint_diagnostics": boolSetting(func(f *folderSettings) *bool { return &f.LintDiags }),
+	"lint":                    setLintRules,
+	"formatting":              setFormatting,
+	"include":                 setFileGlobs,
+	"exclude":                 setFileGlobs,
+}
+
+// settingsSchema maps the keys of the other settings to the function validating and applying their value.
+var settingsSchema = map[string]func(f *serverFlags, key string, value interface{}) error{
+	"enable_eval_diagnostics": flagSetting(func(f *serverFlags) *bool { return &f.evalDiags }),
+	"format_on_save":          flagSetting(func(f *serverFlags) *bool { return &f.formatOnSave }),
+	"inlay_hint_values":       flagSetting(func(f *serverFlags) *bool { return &f.inlayHintValues }),
+	"log_level":               setLogLevel,
+}
+
+// serverFlags are the values of the keys of settingsSchema.
+type serverFlags struct {
+	evalDiags, formatOnSave, inlayHintValues bool
+	logLevel                                 log.Level
+}
+
+// changeConfiguration applies settings from the initialization options or the client configuration.
+// The settings replace the previous ones: keys that are missing are reset to their value from the command line.
+// Unknown keys and invalid values are skipped and reported to the user, the other keys are applied.
+func (s *server) changeConfiguration(ctx context.Context, settings interface{}) error {
+	settingsMap, ok := settings.(map[string]interface{})
 	if !ok {
-		return fmt.Errorf("%w: unsupported settings payload. expected json object, got: %T", jsonrpc2.ErrInvalidParams, params.Settings)
+		return fmt.Errorf("%w: unsupported settings payload. expected json object, got: %T", jsonrpc2.ErrInvalidParams, settings)
+	}
+	settingsMap = expandSettings(settingsMap)
+
+	s.defaultLogLevelOnce.Do(func() {
+		s.defaultLogLevel = log.GetLevel()
+	})
+
+	// Files are read concurrently with the settings, which are applied over a copy
+	globalSettings := s.folderSettings.clone()
+	flags := serverFlags{evalDiags: s.EvalDiags, formatOnSave: s.FormatOnSave, inlayHintValues: s.InlayHintValues, logLevel: s.defaultLogLevel}
+	var problems []string
+	for _, key := range sortedSettingsKeys(settingsMap) {
+		var err error
+		if apply, ok := folderSettingsSchema[key]; ok {
+			err = apply(&globalSettings, s.rootPath, key, settingsMap[key])
+		} else if apply, ok := settingsSchema[key]; ok {
+			err = apply(&flags, key, settingsMap[key])
+		} else {
+			err = fmt.Errorf("unsupported settings key: %q", key)
+		}
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	// Settings of workspace folders apply over the global settings
+	log.SetLevel(flags.logLevel)
+
+	s.foldersMu.Lock()
+	s.globalSettings = &globalSettings
+	s.flags = &flags
+	for _, folder := range s.folders {
+		s.resolveFolderSettings(folder)
+	}
+	s.foldersMu.Unlock()
+
+	s.settingsChanged()
+	s.showSettingsProblems(ctx, "Some settings were ignored", problems)
+	return nil
+}
+
+// changeFolderConfiguration sets the settings of a workspace folder, they apply over the global settings.
+// Keys of settings that cannot differ between folders are ignored, they are applied from the global settings.
+func (s *server) changeFolderConfiguration(ctx context.Context, folder *workspaceFolder, settings interface{}) {
+	settingsMap, ok := settings.(map[string]interface{})
+	if !ok && settings != nil {
+		log.Warnf("changeFolderConfiguration: unsupported settings payload for %s. expected json object, got: %T", folder.path, settings)
+	}
+	if settingsMap != nil {
+		settingsMap = expandSettings(settingsMap)
+	}
+
+	s.foldersMu.Lock()
+	folder.rawSettings = settingsMap
+	problems := s.resolveFolderSettings(folder)
+	s.foldersMu.Unlock()
+
+	s.settingsChanged()
+	s.showSettingsProblems(ctx, fmt.Sprintf("Some settings of the workspace folder %s were ignored", folder.name), problems)
+}
+
+// resolveFolderSettings applies the settings of a workspace folder, then its project config file, over a copy
+// of the global settings. Folders without settings use the global settings. foldersMu must be held.
+func (s *server) resolveFolderSettings(folder *workspaceFolder) (problems []string) {
+	if folder.rawSettings == nil && folder.projectSettings == nil {
+		folder.settings = nil
+		return nil
+	}
+
+	settings := s.globalSettingsLocked().clone()
+	problems = applyFolderSettings(&settings, folder.path, folder.rawSettings)
+	// Problems of the project config file are reported in the file, see reloadProjectConfig
+	applyFolderSettings(&settings, folder.path, folder.projectSettings)
+	folder.settings = &settings
+	return problems
+}
+
+// applyFolderSettings applies the keys of folderSettingsSchema, other keys are ignored.
+func applyFolderSettings(settings *folderSettings, root string, settingsMap map[string]interface{}) (problems []string) {
+	for _, key := range sortedSettingsKeys(settingsMap) {
+		if apply, ok := folderSettingsSchema[key]; ok {
+			if err := apply(settings, root, key, settingsMap[key]); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
 	}
+	return problems
+}
 
-	for sk, sv := range settingsMap {
-		switch sk {
-		case "ext_vars":
-			newVars, err := s.parseExtVars(sv)
-			if err != nil {
-				return fmt.Errorf("%w: ext_vars parsing failed: %v", jsonrpc2.ErrInvalidParams, err)
+// settingsChanged recomputes the diagnostics of open documents, since settings apply to them.
+func (s *server) settingsChanged() {
+	for _, doc := range s.cache.documents() {
+		if doc.ast != nil {
+			doc.val, doc.err = "", nil
+		}
+		s.queueDiagnostics(doc.item.URI)
+	}
+}
+
+// showSettingsProblems warns the user about the settings that could not be applied.
+func (s *server) showSettingsProblems(ctx context.Context, title string, problems []string) {
+	if len(problems) == 0 {
+		return
+	}
+	message := title + ":\n- " + strings.Join(problems, "\n- ")
+	log.Warn(message)
+	if err := s.client.ShowMessage(ctx, &protocol.ShowMessageParams{Type: protocol.Warning, Message: message}); err != nil {
+		log.Errorf("showSettingsProblems: unable to show the settings warning: %v", err)
+	}
+}
+
+func sortedSettingsKeys(settings map[string]interface{}) []string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// expandSettings nests the dotted keys of settings and unwraps the `jsonnet` section, so that
+// `{"jsonnet.formatting.indent": 2}`, `{"jsonnet": {"formatting": {"indent": 2}}}` and
+// `{"formatting": {"indent": 2}}` are equivalent. Only the keys of sections are split on dots.
+func expandSettings(settings map[string]interface{}) map[string]interface{} {
+	expanded := map[string]interface{}{}
+	for key, value := range settings {
+		if section, ok := value.(map[string]interface{}); ok && key == configurationSection {
+			for sectionKey, sectionValue := range expandSettings(section) {
+				setNestedSetting(expanded, []string{sectionKey}, sectionValue)
 			}
-			s.extVars = newVars
+			continue
+		}
+		setNestedSetting(expanded, strings.Split(strings.TrimPrefix(key, configurationSection+"."), "."), value)
+	}
+	return expanded
+}
+
+// setNestedSetting sets a value at a path of nested settings, merging it with the objects already set.
+func setNestedSetting(settings map[string]interface{}, path []string, value interface{}) {
+	key := path[0]
+	if len(path) > 1 {
+		nested, ok := settings[key].(map[string]interface{})
+		if !ok {
+			nested = map[string]interface{}{}
+			settings[key] = nested
+		}
+		setNestedSetting(nested, path[1:], value)
+		return
+	}
 
-		default:
-			return fmt.Errorf("%w: unsupported settings key: %q", jsonrpc2.ErrInvalidParams, sk)
+	existing, existingIsObject := settings[key].(map[string]interface{})
+	values, valueIsObject := value.(map[string]interface{})
+	if existingIsObject && valueIsObject {
+		for k, v := range values {
+			existing[k] = v
 		}
+		return
+	}
+	settings[key] = value
+}
+
+func boolSetting(field func(f *folderSettings) *bool) func(f *folderSettings, root, key string, value interface{}) error {
+	return func(f *folderSettings, root, key string, value interface{}) error {
+		return parseBool(field(f), key, value)
+	}
+}
+
+func flagSetting(field func(f *serverFlags) *bool) func(f *serverFlags, key string, value interface{}) error {
+	return func(f *serverFlags, key string, value interface{}) error {
+		return parseBool(field(f), key, value)
+	}
+}
+
+func parseBool(target *bool, key string, value interface{}) error {
+	b, ok := value.(bool)
+	if !ok {
+		return fmt.Errorf("unsupported settings value for %s. expected boolean. got: %T", key, value)
 	}
+	*target = b
 	return nil
 }
 
-func (s *server) parseExtVars(unparsed interface{}) (map[string]string, error) {
+func setEvalVars(f *folderSettings, root, key string, value interface{}) error {
+	vars, err := parseStringMap(key, value)
+	if err != nil {
+		return err
+	}
+	f.evalSettings.set(key, vars)
+	return nil
+}
+
+func setEvalOverrides(f *folderSettings, root, key string, value interface{}) error {
+	overrides, err := parseEvalOverrides(value)
+	if err != nil {
+		return err
+	}
+	f.evalOverrides = overrides
+	return nil
+}
+
+func setJPath(f *folderSettings, root, key string, value interface{}) error {
+	jpaths, err := parseStringList(key, value)
+	if err != nil {
+		return err
+	}
+	for i, jpath := range jpaths {
+		if !filepath.IsAbs(jpath) && root != "" {
+			jpaths[i] = filepath.Join(root, jpath)
+		}
+	}
+	f.jpaths = jpaths
+	return nil
+}
+
+func setLogLevel(f *serverFlags, key string, value interface{}) error {
+	level, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("unsupported settings value for %s. expected string. got: %T", key, value)
+	}
+	logLevel, err := log.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", key, err)
+	}
+	f.logLevel = logLevel
+	return nil
+}
+
+func setFormatting(f *folderSettings, root, key string, value interface{}) error {
+	opts, err := parseFormattingOpts(value, formatter.DefaultOptions())
+	if err != nil {
+		return err
+	}
+	f.fmtOpts = opts
+	return nil
+}
+
+func setNativeFunctions(f *folderSettings, root, key string, value interface{}) error {
+	stubs, err := parseNativeStubs(key, value)
+	if err != nil {
+		return err
+	}
+	f.nativeStubs = stubs
+	return nil
+}
+
+func setLintRules(f *folderSettings, root, key string, value interface{}) error {
+	rules, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("unsupported settings value for %s. expected json object. got: %T", key, value)
+	}
+
+	lintRules := make(map[string]bool, len(rules))
+	for rule, enabled := range rules {
+		if _, ok := lintRuleMessages[rule]; !ok {
+			return fmt.Errorf("unsupported settings key: \"%s.%s\"", key, rule)
+		}
+		if err := parseBool(new(bool), key+"."+rule, enabled); err != nil {
+			return err
+		}
+		lintRules[rule] = enabled.(bool)
+	}
+	f.lintRules = lintRules
+	return nil
+}
+
+func setFileGlobs(f *folderSettings, root, key string, value interface{}) error {
+	patterns, err := parseStringList(key, value)
+	if err != nil {
+		return err
+	}
+
+	globs := make([]glob.Glob, 0, len(patterns))
+	for i, pattern := range patterns {
+		if !strings.HasPrefix(pattern, "/") && root != "" {
+			pattern = strings.TrimSuffix(filepath.ToSlash(root), "/") + "/" + pattern
+		}
+		compiled, err := glob.Compile(pattern, '/')
+		if err != nil {
+			return fmt.Errorf("invalid glob for %s[%d]: %v", key, i, err)
+		}
+		globs = append(globs, compiled)
+	}
+	if key == "include" {
+		f.include = globs
+	} else {
+		f.exclude = globs
+	}
+	return nil
+}
+
+// parseStringMap parses the settings value of a map of strings, such as ext_vars.
+func parseStringMap(name string, unparsed interface{}) (map[string]string, error) {
 	newVars, ok := unparsed.(map[string]interface{})
 	if !ok {
-		return nil, fmt.Errorf("unsupported settings value for ext_vars. expected json object. got: %T", unparsed)
+		return nil, fmt.Errorf("unsupported settings value for %s. expected json object. got: %T", name, unparsed)
 	}
 
-	extVars := make(map[string]string, len(newVars))
+	vars := make(map[string]string, len(newVars))
 	for varKey, varValue := range newVars {
 		vv, ok := varValue.(string)
 		if !ok {
-			return nil, fmt.Errorf("unsupported settings value for ext_vars.%s. expected string. got: %T", varKey, varValue)
+			return nil, fmt.Errorf("unsupported settings value for %s.%s. expected string. got: %T", name, varKey, varValue)
 		}
-		extVars[varKey] = vv
+		vars[varKey] = vv
 	}
-	return extVars, nil
+	return vars, nil
 }
 
-func resetExtVars(vm *jsonnet.VM, vars map[string]string) {
+// parseStringList parses the settings value of a list of strings, such as jpath.
+func parseStringList(name string, unparsed interface{}) ([]string, error) {
+	items, ok := unparsed.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unsupported settings value for %s. expected json array. got: %T", name, unparsed)
+	}
+
+	list := make([]string, 0, len(items))
+	for i, item := range items {
+		value, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("unsupported settings value for %s[%d]. expected string. got: %T", name, i, item)
+		}
+		list = append(list, value)
+	}
+	return list, nil
+}
+
+// evalSettings are the external variables and top-level arguments passed to the evaluation of files.
+// Values of ExtCode and TLACode are Jsonnet code.
+type evalSettings struct {
+	ExtVars map[string]string
+	ExtCode map[string]string
+	TLAStr  map[string]string
+	TLACode map[string]string
+}
+
+// set replaces the variables of a settings key.
+func (e *evalSettings) set(key string, vars map[string]string) {
+	switch key {
+	case "ext_vars":
+		e.ExtVars = vars
+	case "ext_code":
+		e.ExtCode = vars
+	case "tla_str":
+		e.TLAStr = vars
+	case "tla_code":
+		e.TLACode = vars
+	}
+}
+
+// merge returns the settings with the variables of other set over them.
+// A variable of other replaces the variable of the same name, whether it is a string or code.
+func (e evalSettings) merge(other evalSettings) evalSettings {
+	merged := evalSettings{
+		ExtVars: copyStringMap(e.ExtVars),
+		ExtCode: copyStringMap(e.ExtCode),
+		TLAStr:  copyStringMap(e.TLAStr),
+		TLACode: copyStringMap(e.TLACode),
+	}
+	for name, value := range other.ExtVars {
+		delete(merged.ExtCode, name)
+		merged.ExtVars[name] = value
+	}
+	for name, value := range other.ExtCode {
+		delete(merged.ExtVars, name)
+		merged.ExtCode[name] = value
+	}
+	for name, value := range other.TLAStr {
+		delete(merged.TLACode, name)
+		merged.TLAStr[name] = value
+	}
+	for name, value := range other.TLACode {
+		delete(merged.TLAStr, name)
+		merged.TLACode[name] = value
+	}
+	return merged
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+func copyBoolMap(m map[string]bool) map[string]bool {
+	copied := make(map[string]bool, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+// evalOverride sets evaluation settings for the files matching a glob.
+type evalOverride struct {
+	files glob.Glob
+	evalSettings
+}
+
+// parseEvalOverrides parses a list of overrides, such as:
+//
+//	[{"files": "environments/*/main.jsonnet", "tla_str": {"cluster": "dev"}}]
+//
+// Globs that are not absolute match the end of file paths.
+func parseEvalOverrides(unparsed interface{}) ([]evalOverride, error) {
+	list, ok := unparsed.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unsupported settings value for overrides. expected json array. got: %T", unparsed)
+	}
+
+	overrides := make([]evalOverride, 0, len(list))
+	for i, item := range list {
+		settingsMap, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unsupported settings value for overrides[%d]. expected json object. got: %T", i, item)
+		}
+
+		var override evalOverride
+		for sk, sv := range settingsMap {
+			switch sk {
+			case "files":
+				pattern, ok := sv.(string)
+				if !ok {
+					return nil, fmt.Errorf("unsupported settings value for overrides[%d].files. expected string. got: %T", i, sv)
+				}
+				if !strings.HasPrefix(pattern, "/") && !strings.HasPrefix(pattern, "**") {
+					pattern = "**/" + pattern
+				}
+				files, err := glob.Compile(pattern, '/')
+				if err != nil {
+					return nil, fmt.Errorf("invalid glob for overrides[%d].files: %v", i, err)
+				}
+				override.files = files
+			case "ext_vars", "ext_code", "tla_str", "tla_code":
+				vars, err := parseStringMap(fmt.Sprintf("overrides[%d].%s", i, sk), sv)
+				if err != nil {
+					return nil, err
+				}
+				override.set(sk, vars)
+			default:
+				return nil, fmt.Errorf("unsupported settings key: \"overrides[%d].%s\"", i, sk)
+			}
+		}
+		if override.files == nil {
+			return nil, fmt.Errorf("missing settings key: \"overrides[%d].files\"", i)
+		}
+		overrides = append(overrides, override)
+	}
+	return overrides, nil
+}
+
+// evalSettingsFor returns the evaluation settings of a file, with the matching overrides applied in order.
+// The settings of the workspace folder containing the file are used.
+func (s *server) evalSettingsFor(path string) evalSettings {
+	folderSettings := s.folderSettingsFor(path)
+	settings := folderSettings.evalSettings.merge(evalSettings{})
+	for _, override := range folderSettings.evalOverrides {
+		if override.files.Match(filepath.ToSlash(path)) {
+			settings = settings.merge(override.evalSettings)
+		}
+	}
+	return settings
+}
+
+// resetEvalSettings replaces the external variables and top-level arguments of a VM.
+func resetEvalSettings(vm *jsonnet.VM, settings evalSettings) {
 	vm.ExtReset()
-	for vk, vv := range vars {
+	vm.TLAReset()
+	for vk, vv := range settings.ExtVars {
 		vm.ExtVar(vk, vv)
 	}
+	for vk, vv := range settings.ExtCode {
+		vm.ExtCode(vk, vv)
+	}
+	for vk, vv := range settings.TLAStr {
+		vm.TLAVar(vk, vv)
+	}
+	for vk, vv := range settings.TLACode {
+		vm.TLACode(vk, vv)
+	}
 }
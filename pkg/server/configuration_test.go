@@ -3,10 +3,14 @@ package server
 import (
 	"context"
 	"errors"
+	"path/filepath"
+	"sync"
 	"testing"
 
+	"github.com/google/go-jsonnet/formatter"
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestConfiguration(t *testing.T) {
@@ -16,6 +20,7 @@ func TestConfiguration(t *testing.T) {
 		fileContent string
 
 		expectedErr        error
+		expectedWarning    string
 		expectedFileOutput string
 	}
 
@@ -31,8 +36,9 @@ func TestConfiguration(t *testing.T) {
 			settings: map[string]interface{}{
 				"foo_bar": map[string]interface{}{},
 			},
-			fileContent: `[]`,
-			expectedErr: errors.New("JSON RPC invalid params: unsupported settings key: \"foo_bar\""),
+			fileContent:        `[]`,
+			expectedWarning:    "unsupported settings key: \"foo_bar\"",
+			expectedFileOutput: `[]`,
 		},
 		{
 			name: "ext_var config is empty",
@@ -53,8 +59,9 @@ func TestConfiguration(t *testing.T) {
 			settings: map[string]interface{}{
 				"ext_vars": []string{},
 			},
-			fileContent: `[]`,
-			expectedErr: errors.New("JSON RPC invalid params: ext_vars parsing failed: unsupported settings value for ext_vars. expected json object. got: []string"),
+			fileContent:        `[]`,
+			expectedWarning:    "unsupported settings value for ext_vars. expected json object. got: []string",
+			expectedFileOutput: `[]`,
 		},
 		{
 			name: "ext_var config value is not a string",
@@ -63,8 +70,27 @@ func TestConfiguration(t *testing.T) {
 					"foo": true,
 				},
 			},
-			fileContent: `[]`,
-			expectedErr: errors.New("JSON RPC invalid params: ext_vars parsing failed: unsupported settings value for ext_vars.foo. expected string. got: bool"),
+			fileContent:        `[]`,
+			expectedWarning:    "unsupported settings value for ext_vars.foo. expected string. got: bool",
+			expectedFileOutput: `[]`,
+		},
+		{
+			name: "formatting config is not an object",
+			settings: map[string]interface{}{
+				"formatting": "2",
+			},
+			fileContent:        `[]`,
+			expectedWarning:    "unsupported settings value for formatting. expected json object. got: string",
+			expectedFileOutput: `[]`,
+		},
+		{
+			name: "inlay_hint_values is not a boolean",
+			settings: map[string]interface{}{
+				"inlay_hint_values": "true",
+			},
+			fileContent:        `[]`,
+			expectedWarning:    "unsupported settings value for inlay_hint_values. expected boolean. got: string",
+			expectedFileOutput: `[]`,
 		},
 		{
 			name: "ext_var config is valid",
@@ -84,11 +110,105 @@ func TestConfiguration(t *testing.T) {
 }
 			`,
 		},
+		{
+			name: "ext_code config is valid",
+			settings: map[string]interface{}{
+				"ext_code": map[string]interface{}{
+					"replicas": "{ dev: 1, prod: 3 }",
+				},
+			},
+			fileContent:        `std.extVar("replicas").prod`,
+			expectedFileOutput: `3`,
+		},
+		{
+			name: "tla config is valid",
+			settings: map[string]interface{}{
+				"tla_str": map[string]interface{}{
+					"cluster": "dev",
+				},
+				"tla_code": map[string]interface{}{
+					"replicas": "1 + 1",
+				},
+			},
+			fileContent:        `function(cluster, replicas) { cluster: cluster, replicas: replicas }`,
+			expectedFileOutput: `{"cluster": "dev", "replicas": 2}`,
+		},
+		{
+			name: "tla_code config value is not a string",
+			settings: map[string]interface{}{
+				"tla_code": map[string]interface{}{
+					"replicas": 2,
+				},
+			},
+			fileContent:        `[]`,
+			expectedWarning:    "unsupported settings value for tla_code.replicas. expected string. got: int",
+			expectedFileOutput: `[]`,
+		},
+		{
+			name: "overrides config is not an array",
+			settings: map[string]interface{}{
+				"overrides": map[string]interface{}{},
+			},
+			fileContent:        `[]`,
+			expectedWarning:    "unsupported settings value for overrides. expected json array. got: map[string]interface {}",
+			expectedFileOutput: `[]`,
+		},
+		{
+			name: "overrides config has no files",
+			settings: map[string]interface{}{
+				"overrides": []interface{}{
+					map[string]interface{}{"ext_vars": map[string]interface{}{}},
+				},
+			},
+			fileContent:        `[]`,
+			expectedWarning:    "missing settings key: \"overrides[0].files\"",
+			expectedFileOutput: `[]`,
+		},
+		{
+			name: "overrides config has an unsupported key",
+			settings: map[string]interface{}{
+				"overrides": []interface{}{
+					map[string]interface{}{"files": "*.jsonnet", "formatting": map[string]interface{}{}},
+				},
+			},
+			fileContent:        `[]`,
+			expectedWarning:    "unsupported settings key: \"overrides[0].formatting\"",
+			expectedFileOutput: `[]`,
+		},
+		{
+			name: "jpath config is not a list of strings",
+			settings: map[string]interface{}{
+				"jpath": []interface{}{"lib", 1},
+			},
+			fileContent:        `[]`,
+			expectedWarning:    "unsupported settings value for jpath[1]. expected string. got: int",
+			expectedFileOutput: `[]`,
+		},
+		{
+			name: "enable_eval_diagnostics is not a boolean",
+			settings: map[string]interface{}{
+				"enable_eval_diagnostics": "true",
+			},
+			fileContent:        `[]`,
+			expectedWarning:    "unsupported settings value for enable_eval_diagnostics. expected boolean. got: string",
+			expectedFileOutput: `[]`,
+		},
+		{
+			name: "log_level is invalid",
+			settings: map[string]interface{}{
+				"log_level": "loud",
+			},
+			fileContent:        `[]`,
+			expectedWarning:    "invalid log_level: not a valid logrus Level: \"loud\"",
+			expectedFileOutput: `[]`,
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			s, fileURI := testServerWithFile(t, nil, tc.fileContent)
+			client := &configurationClient{}
+			s.client = client
 
 			err := s.DidChangeConfiguration(
 				context.TODO(),
@@ -105,6 +225,14 @@ func TestConfiguration(t *testing.T) {
 				return
 			}
 
+			if tc.expectedWarning == "" {
+				assert.Empty(t, client.messages)
+			} else {
+				require.Len(t, client.messages, 1)
+				assert.Equal(t, protocol.Warning, client.messages[0].Type)
+				assert.Equal(t, "Some settings were ignored:\n- "+tc.expectedWarning, client.messages[0].Message)
+			}
+
 			vm, err := s.getVM("any")
 			assert.NoError(t, err)
 
@@ -117,3 +245,270 @@ func TestConfiguration(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigurationOverrides(t *testing.T) {
+	s := testServer(t, nil)
+	err := s.DidChangeConfiguration(context.TODO(), &protocol.DidChangeConfigurationParams{
+		Settings: map[string]interface{}{
+			"ext_vars": map[string]interface{}{"cluster": "default", "region": "eu"},
+			"overrides": []interface{}{
+				map[string]interface{}{
+					"files":    "environments/*/main.jsonnet",
+					"ext_code": map[string]interface{}{"cluster": "{ name: 'dev' }"},
+				},
+				map[string]interface{}{
+					"files":    "/abs/environments/prod/*.jsonnet",
+					"ext_vars": map[string]interface{}{"region": "us"},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		path     string
+		expected string
+	}{
+		{path: "/abs/lib/app.libsonnet", expected: `["default", "eu"]`},
+		{path: "/abs/environments/dev/main.jsonnet", expected: `[{"name": "dev"}, "eu"]`},
+		{path: "/abs/environments/prod/main.jsonnet", expected: `[{"name": "dev"}, "us"]`},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			vm, err := s.getVM(tc.path)
+			require.NoError(t, err)
+			result, err := vm.EvaluateAnonymousSnippet(tc.path, `[std.extVar("cluster"), std.extVar("region")]`)
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.expected, result)
+		})
+	}
+}
+
+func TestConfigurationVM(t *testing.T) {
+	s := testServer(t, nil)
+	s.rootPath, _ = filepath.Abs("testdata")
+	err := s.DidChangeConfiguration(context.TODO(), &protocol.DidChangeConfigurationParams{
+		Settings: map[string]interface{}{
+			"jpath":                   []interface{}{"tanka/lib"},
+			"enable_eval_diagnostics": true,
+			"enable_lint_diagnostics": true,
+			"log_level":               "warning",
+		},
+	})
+	require.NoError(t, err)
+	assert.True(t, s.featureFlags().evalDiags)
+	assert.True(t, s.folderSettingsFor("").LintDiags)
+	assert.False(t, s.folderSettingsFor("").tankaMode)
+
+	// Relative jpaths are resolved from the workspace root
+	vm, err := s.getVM("/tmp/main.jsonnet")
+	require.NoError(t, err)
+	result, err := vm.EvaluateAnonymousSnippet("/tmp/main.jsonnet", `(import 'app.libsonnet').configMap('a', {}).kind`)
+	require.NoError(t, err)
+	assert.Equal(t, "\"ConfigMap\"\n", result)
+
+	// Tanka mode finds the jpath of files in Tanka projects
+	err = s.DidChangeConfiguration(context.TODO(), &protocol.DidChangeConfigurationParams{
+		Settings: map[string]interface{}{"tanka_mode": true},
+	})
+	require.NoError(t, err)
+	assert.True(t, s.folderSettingsFor("").tankaMode)
+	environment, err := filepath.Abs("testdata/tanka/environments/default/main.jsonnet")
+	require.NoError(t, err)
+	assert.Contains(t, s.getJPaths(environment), filepath.Join(filepath.Dir(filepath.Dir(filepath.Dir(environment))), "lib"))
+}
+
+// configurationClient answers `workspace/configuration` requests with fixed settings and records the messages shown.
+// folderSettings are the settings of each scope URI. The last diagnostics published for each URI, and the number
+// of times they were published, are recorded.
+type configurationClient struct {
+	protocol.ClientCloser
+	settings       interface{}
+	folderSettings map[string]interface{}
+	requests       []*protocol.ParamConfiguration
+	messages       []*protocol.ShowMessageParams
+	registrations  []protocol.Registration
+
+	diagnosticsMu sync.Mutex
+	diagnostics   map[protocol.DocumentURI][]protocol.Diagnostic
+	publications  map[protocol.DocumentURI]int
+}
+
+func (c *configurationClient) Configuration(ctx context.Context, params *protocol.ParamConfiguration) ([]interface{}, error) {
+	c.requests = append(c.requests, params)
+	var result []interface{}
+	for _, item := range params.Items {
+		if item.ScopeURI == "" {
+			result = append(result, c.settings)
+		} else {
+			result = append(result, c.folderSettings[item.ScopeURI])
+		}
+	}
+	return result, nil
+}
+
+func (c *configurationClient) ShowMessage(ctx context.Context, params *protocol.ShowMessageParams) error {
+	c.messages = append(c.messages, params)
+	return nil
+}
+
+func (c *configurationClient) PublishDiagnostics(ctx context.Context, params *protocol.PublishDiagnosticsParams) error {
+	c.diagnosticsMu.Lock()
+	defer c.diagnosticsMu.Unlock()
+	if c.diagnostics == nil {
+		c.diagnostics = map[protocol.DocumentURI][]protocol.Diagnostic{}
+		c.publications = map[protocol.DocumentURI]int{}
+	}
+	c.diagnostics[params.URI] = params.Diagnostics
+	c.publications[params.URI]++
+	return nil
+}
+
+func (c *configurationClient) publishedDiagnostics(uri protocol.DocumentURI) []protocol.Diagnostic {
+	c.diagnosticsMu.Lock()
+	defer c.diagnosticsMu.Unlock()
+	return c.diagnostics[uri]
+}
+
+func (c *configurationClient) publicationCount(uri protocol.DocumentURI) int {
+	c.diagnosticsMu.Lock()
+	defer c.diagnosticsMu.Unlock()
+	return c.publications[uri]
+}
+
+func (c *configurationClient) RegisterCapability(ctx context.Context, params *protocol.RegistrationParams) error {
+	c.registrations = append(c.registrations, params.Registrations...)
+	return nil
+}
+
+func TestConfigurationPull(t *testing.T) {
+	client := &configurationClient{settings: map[string]interface{}{"enable_lint_diagnostics": true}}
+	s := NewServer("jsonnet-language-server", "dev", client).WithStaticVM([]string{})
+	params := &protocol.ParamInitialize{}
+	params.Capabilities.Workspace.Configuration = true
+	params.InitializationOptions = map[string]interface{}{"enable_eval_diagnostics": true}
+	_, err := s.Initialize(context.Background(), params)
+	require.NoError(t, err)
+	assert.True(t, s.featureFlags().evalDiags, "initialization options are applied")
+
+	require.NoError(t, s.Initialized(context.Background(), &protocol.InitializedParams{}))
+	assert.True(t, s.folderSettingsFor("").LintDiags, "settings are pulled once initialized")
+	require.Len(t, client.requests, 1)
+	assert.Equal(t, []protocol.ConfigurationItem{{Section: "jsonnet"}}, client.requests[0].Items)
+
+	// Empty notifications pull the settings again
+	client.settings = map[string]interface{}{"enable_lint_diagnostics": false}
+	require.NoError(t, s.DidChangeConfiguration(context.Background(), &protocol.DidChangeConfigurationParams{}))
+	assert.False(t, s.folderSettingsFor("").LintDiags)
+	assert.Len(t, client.requests, 2)
+
+	// Pushed settings are applied as is
+	require.NoError(t, s.DidChangeConfiguration(context.Background(), &protocol.DidChangeConfigurationParams{
+		Settings: map[string]interface{}{"enable_lint_diagnostics": true},
+	}))
+	assert.True(t, s.folderSettingsFor("").LintDiags)
+	assert.Len(t, client.requests, 2)
+}
+
+func TestConfigurationReset(t *testing.T) {
+	s := NewServer("jsonnet-language-server", "dev", &configurationClient{}).WithStaticVM([]string{"/flags"})
+	s.LintDiags = true
+
+	require.NoError(t, s.DidChangeConfiguration(context.Background(), &protocol.DidChangeConfigurationParams{
+		Settings: map[string]interface{}{
+			"enable_lint_diagnostics": false,
+			"format_on_save":          true,
+			"jpath":                   []interface{}{"/settings"},
+			"formatting":              map[string]interface{}{"indent": 4.0},
+		},
+	}))
+	settings := s.folderSettingsFor("")
+	assert.False(t, settings.LintDiags)
+	assert.True(t, s.featureFlags().formatOnSave)
+	assert.Equal(t, []string{"/settings"}, settings.jpaths)
+	assert.Equal(t, 4, settings.fmtOpts.Indent)
+
+	// Keys missing from the new settings are reset to their value from the command line
+	require.NoError(t, s.DidChangeConfiguration(context.Background(), &protocol.DidChangeConfigurationParams{
+		Settings: map[string]interface{}{"ext_vars": map[string]interface{}{"env": "dev"}},
+	}))
+	assert.True(t, s.folderSettingsFor("").LintDiags)
+	assert.False(t, s.featureFlags().formatOnSave)
+	assert.Equal(t, []string{"/flags"}, s.folderSettingsFor("").jpaths)
+	assert.Equal(t, formatter.DefaultOptions().Indent, s.folderSettingsFor("").fmtOpts.Indent)
+	assert.Equal(t, map[string]string{"env": "dev"}, s.folderSettingsFor("").evalSettings.ExtVars)
+
+	// Settings returned before the change are left as is
+	assert.False(t, settings.LintDiags)
+	assert.Equal(t, []string{"/settings"}, settings.jpaths)
+}
+
+func TestConfigurationConcurrentReads(t *testing.T) {
+	s := NewServer("jsonnet-language-server", "dev", &configurationClient{}).WithStaticVM([]string{})
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				settings := s.folderSettingsFor("/tmp/main.jsonnet")
+				_ = settings.LintDiags
+				_ = len(settings.jpaths)
+				_ = s.evalSettingsFor("/tmp/main.jsonnet")
+				flags := s.featureFlags()
+				_ = flags.evalDiags && flags.formatOnSave && flags.inlayHintValues
+			}
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, s.changeConfiguration(context.Background(), map[string]interface{}{
+			"enable_lint_diagnostics": i%2 == 0,
+			"enable_eval_diagnostics": i%2 == 0,
+			"format_on_save":          i%3 == 0,
+			"inlay_hint_values":       i%2 == 1,
+			"jpath":                   []interface{}{"/lib"},
+			"ext_vars":                map[string]interface{}{"i": "value"},
+		}))
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestConfigurationSections(t *testing.T) {
+	s := testServer(t, nil)
+	client := &configurationClient{}
+	s.client = client
+
+	err := s.DidChangeConfiguration(context.TODO(), &protocol.DidChangeConfigurationParams{
+		Settings: map[string]interface{}{
+			"jsonnet": map[string]interface{}{
+				"formatting": map[string]interface{}{"indent": 4.0},
+				"ext_vars":   map[string]interface{}{"tanka.dev/environment": "dev"},
+			},
+			"jsonnet.formatting.max_blank_lines": 1.0,
+			"jsonnet.enable_lint_diagnostics":    true,
+			"jsonnet.format_on_save":             "yes",
+			"editor":                             map[string]interface{}{"tabSize": 2},
+		},
+	})
+	require.NoError(t, err)
+
+	// Valid keys are applied
+	assert.Equal(t, 4, s.folderSettingsFor("").fmtOpts.Indent)
+	assert.Equal(t, 1, s.folderSettingsFor("").fmtOpts.MaxBlankLines)
+	assert.True(t, s.folderSettingsFor("").LintDiags)
+	assert.Equal(t, map[string]string{"tanka.dev/environment": "dev"}, s.folderSettingsFor("").evalSettings.ExtVars)
+
+	// Invalid ones are reported together
+	assert.False(t, s.featureFlags().formatOnSave)
+	require.Len(t, client.messages, 1)
+	assert.Equal(t, "Some settings were ignored:\n"+
+		"- unsupported settings key: \"editor\"\n"+
+		"- unsupported settings value for format_on_save. expected boolean. got: string",
+		client.messages[0].Message)
+}
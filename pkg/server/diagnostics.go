@@ -3,14 +3,21 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"path"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
 	"github.com/google/go-jsonnet/linter"
 	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 	log "github.com/sirupsen/logrus"
 )
@@ -50,21 +57,32 @@ func (s *server) diagnosticsLoop() {
 					}
 
 					diags := []protocol.Diagnostic{}
+					settings := s.folderSettingsFor(uri.SpanURI().Filename())
+					diagnosed := settings.diagnosed(uri.SpanURI().Filename())
 					evalChannel := make(chan []protocol.Diagnostic, 1)
-					go func() {
-						evalChannel <- s.getEvalDiags(doc)
-					}()
+					if diagnosed {
+						go func() {
+							evalChannel <- s.getEvalDiags(doc)
+						}()
+					} else {
+						evalChannel <- nil
+					}
 
+					lintDiags := diagnosed && settings.LintDiags
 					lintChannel := make(chan []protocol.Diagnostic, 1)
-					if s.LintDiags {
+					if lintDiags {
 						go func() {
 							lintChannel <- s.getLintDiags(doc)
 						}()
 					}
 
+					if diagnosed {
+						diags = append(diags, s.getImportDiags(doc)...)
+						diags = append(diags, s.getBundlerImportDiags(doc)...)
+					}
 					diags = append(diags, <-evalChannel...)
 
-					if s.LintDiags {
+					if lintDiags {
 						err = s.client.PublishDiagnostics(context.Background(), &protocol.PublishDiagnosticsParams{
 							URI:         uri,
 							Diagnostics: diags,
@@ -100,13 +118,29 @@ func (s *server) diagnosticsLoop() {
 }
 
 func (s *server) getEvalDiags(doc *document) (diags []protocol.Diagnostic) {
-	if doc.err == nil && s.EvalDiags {
+	if doc.err == nil && s.featureFlags().evalDiags {
 		vm, err := s.getVM(doc.item.URI.SpanURI().Filename())
 		if err != nil {
 			log.Errorf("getEvalDiags: %s: %v\n", errorRetrievingDocument, err)
 			return
 		}
-		doc.val, doc.err = vm.EvaluateAnonymousSnippet(doc.item.URI.SpanURI().Filename(), doc.item.Text)
+		filename := doc.item.URI.SpanURI().Filename()
+		if name := s.selectedInlineEnvironment(filename); name != "" {
+			// The document is imported so that errors keep its locations
+			vm.Importer(&documentImporter{Importer: s.getImporter(filename), filename: filename, text: doc.item.Text})
+			quotedFilename, _ := json.Marshal(filename)
+			doc.val, doc.err = vm.EvaluateAnonymousSnippet("<inline environment>", inlineEnvironmentCode(fmt.Sprintf("import %s", quotedFilename), name))
+		} else {
+			var placeholders []string
+			diags, placeholders = s.getTopLevelArgumentDiags(doc, vm)
+			errFormatter := &rawErrorFormatter{ErrorFormatter: vm.ErrorFormatter}
+			vm.ErrorFormatter = errFormatter
+			doc.val, doc.err = vm.EvaluateAnonymousSnippet(filename, doc.item.Text)
+			// Errors from missing top-level arguments are already reported by their hints
+			if doc.err != nil && isPlaceholderError(errFormatter.err, placeholders) {
+				return diags
+			}
+		}
 	}
 
 	// Initialize with 1 because we indiscriminately subtract one to map error ranges to LSP ranges.
@@ -161,12 +195,127 @@ func (s *server) getEvalDiags(doc *document) (diags []protocol.Diagnostic) {
 	return diags
 }
 
+// missingTopLevelArgumentError is raised by the placeholders of the top-level arguments that have no value.
+const missingTopLevelArgumentError = "no value configured for top-level argument"
+
+// getTopLevelArgumentDiags reports the required parameters of a file that evaluates to a function
+// when they have no value configured. They are bound to placeholders, whose names are returned,
+// so that the rest of the file is evaluated.
+func (s *server) getTopLevelArgumentDiags(doc *document, vm *jsonnet.VM) (diags []protocol.Diagnostic, placeholders []string) {
+	function, ok := topLevelExpression(doc.ast).(*ast.Function)
+	if !ok {
+		return nil, nil
+	}
+
+	settings := s.evalSettingsFor(doc.item.URI.SpanURI().Filename())
+	for _, param := range function.Parameters {
+		name := string(param.Name)
+		_, isStr := settings.TLAStr[name]
+		_, isCode := settings.TLACode[name]
+		if param.DefaultArg != nil || isStr || isCode {
+			continue
+		}
+
+		message := fmt.Sprintf("%s %q", missingTopLevelArgumentError, name)
+		quotedMessage, _ := json.Marshal(message)
+		vm.TLACode(name, fmt.Sprintf("error %s", quotedMessage))
+		placeholders = append(placeholders, name)
+		diags = append(diags, protocol.Diagnostic{
+			Range:    position.RangeASTToProtocol(param.LocRange),
+			Severity: protocol.SeverityHint,
+			Source:   "jsonnet evaluation",
+			Message:  message + ". Set it with the tla_str or tla_code settings",
+		})
+	}
+	return diags, placeholders
+}
+
+// isPlaceholderError returns whether an evaluation error is raised by the placeholder of a top-level argument,
+// rather than by the file. Top-level arguments are evaluated as files named `<top-level-arg:name>`.
+func isPlaceholderError(err error, placeholders []string) bool {
+	runtimeErr, ok := err.(jsonnet.RuntimeError)
+	if !ok || len(runtimeErr.StackTrace) == 0 {
+		return false
+	}
+	// The stack trace starts with the outermost frame
+	source := runtimeErr.StackTrace[len(runtimeErr.StackTrace)-1].Loc.File
+	if source == nil {
+		return false
+	}
+	for _, name := range placeholders {
+		if string(source.DiagnosticFileName) == fmt.Sprintf("<top-level-arg:%s>", name) {
+			return true
+		}
+	}
+	return false
+}
+
+// getImportDiags reports the imports that cannot be resolved, without evaluating the document.
+func (s *server) getImportDiags(doc *document) (diags []protocol.Diagnostic) {
+	if doc.ast == nil {
+		return
+	}
+
+	filename := doc.item.URI.SpanURI().Filename()
+	vm, err := s.getVM(filename)
+	if err != nil {
+		log.Errorf("getImportDiags: error creating the VM: %v\n", err)
+		return
+	}
+
+	for _, importPath := range findImportPaths(doc.ast) {
+		if _, err := vm.ResolveImport(filename, importPath.Value); err == nil {
+			continue
+		}
+		searchPaths := s.importSearchPaths(filename)
+		message := fmt.Sprintf("unable to resolve import %q, searched in: %s", importPath.Value, strings.Join(searchPaths, ", "))
+		if suggestion := suggestImport(searchPaths, importPath.Value); suggestion != "" {
+			message += fmt.Sprintf(". Did you mean %q?", suggestion)
+		}
+		diags = append(diags, protocol.Diagnostic{
+			Range:    position.RangeASTToProtocol(*importPath.Loc()),
+			Severity: protocol.SeverityError,
+			Source:   "jsonnet imports",
+			Message:  message,
+		})
+	}
+	return diags
+}
+
+// suggestImport looks for the file with the closest name to the missing import, in the same directory of every search path.
+// Only files that are a few edits away are suggested.
+func suggestImport(searchPaths []string, importPath string) string {
+	importDir, importFile := path.Split(importPath)
+	maxDistance := len(importFile) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+
+	suggestion, bestDistance := "", maxDistance+1
+	for _, searchPath := range searchPaths {
+		entries, err := os.ReadDir(filepath.Join(searchPath, filepath.FromSlash(importDir)))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if distance := utils.LevenshteinDistance(importFile, entry.Name()); distance < bestDistance {
+				suggestion, bestDistance = importDir+entry.Name(), distance
+			}
+		}
+	}
+	return suggestion
+}
+
 func (s *server) getLintDiags(doc *document) (diags []protocol.Diagnostic) {
+	lintRules := s.folderSettingsFor(doc.item.URI.SpanURI().Filename()).lintRules
 	result, err := s.lintWithRecover(doc)
 	if err != nil {
 		log.Errorf("getLintDiags: %s: %v\n", errorRetrievingDocument, err)
 	} else {
 		for _, match := range errRegexp.FindAllStringSubmatch(result, -1) {
+			if !lintRuleEnabled(lintRules, match[9]) {
+				continue
+			}
 			line, col, endLine, endCol := 1, 1, 1, 1
 			diag := protocol.Diagnostic{Source: "lint", Severity: protocol.SeverityWarning}
 
@@ -199,6 +348,48 @@ func (s *server) getLintDiags(doc *document) (diags []protocol.Diagnostic) {
 	return diags
 }
 
+// lintRuleMessages match the messages of each lint rule that can be disabled with the `lint` setting.
+// The linter only reports messages, they are the messages of go-jsonnet's linter.
+var lintRuleMessages = map[string][]*regexp.Regexp{
+	"unused_variables": {regexp.MustCompile(`^Unused variable: \w+$`)},
+	"type_errors": {
+		regexp.MustCompile(`^Too few arguments: got \d+, but expected at least \d+$`),
+		regexp.MustCompile(`^Too many arguments: got \d+, but expected at most \d+$`),
+		regexp.MustCompile(`^Too many arguments, there can be at most \d+, but \d+ provided$`),
+		regexp.MustCompile(`^Called value must be a function, but it is assumed to be .+$`),
+		regexp.MustCompile(`^Indexed object has no field ".*"$`),
+		regexp.MustCompile(`^Indexed value is neither an array nor an object nor a string$`),
+		regexp.MustCompile(`^Indexed value is assumed to be .+, but index is not a (number|string)$`),
+		regexp.MustCompile(`^Index is neither a number \(for indexing arrays and string\) nor a string \(for indexing objects\)$`),
+		regexp.MustCompile(`^Operand is not a (number|boolean), it is assumed to be .+$`),
+		regexp.MustCompile(`^Argument \w+ already provided$`),
+		regexp.MustCompile(`^function has no parameter \w+$`),
+		regexp.MustCompile(`^Missing argument: \w+$`),
+	},
+}
+
+// lintRule returns the rule of a lint message, if it is one of lintRuleMessages.
+func lintRule(message string) (string, bool) {
+	for rule, patterns := range lintRuleMessages {
+		for _, pattern := range patterns {
+			if pattern.MatchString(message) {
+				return rule, true
+			}
+		}
+	}
+	return "", false
+}
+
+// lintRuleEnabled returns whether the rule of a lint message is enabled. Rules are enabled by default.
+func lintRuleEnabled(rules map[string]bool, message string) bool {
+	rule, ok := lintRule(message)
+	if !ok {
+		return true
+	}
+	enabled, configured := rules[rule]
+	return enabled || !configured
+}
+
 func (s *server) lintWithRecover(doc *document) (result string, err error) {
 	defer func() {
 		if r := recover(); r != nil {
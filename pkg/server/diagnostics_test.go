@@ -1,16 +1,20 @@
 package server
 
 import (
+	"context"
 	"testing"
 
+	"github.com/grafana/jsonnet-language-server/pkg/position"
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetLintDiags(t *testing.T) {
 	testCases := []struct {
 		name        string
 		fileContent string
+		settings    map[string]interface{}
 		expected    []protocol.Diagnostic
 	}{
 		{
@@ -31,10 +35,21 @@ local unused = 'test';
 				},
 			},
 		},
+		{
+			name: "disabled rule",
+			fileContent: `
+local unused = 'test';
+{}
+`,
+			settings: map[string]interface{}{"lint": map[string]interface{}{"unused_variables": false}},
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			s, fileURI := testServerWithFile(t, nil, tc.fileContent)
+			if tc.settings != nil {
+				require.NoError(t, s.changeConfiguration(context.Background(), tc.settings))
+			}
 			doc, err := s.cache.get(fileURI)
 			if err != nil {
 				t.Fatalf("%s: %v", errorRetrievingDocument, err)
@@ -45,3 +60,131 @@ local unused = 'test';
 		})
 	}
 }
+
+func TestLintRules(t *testing.T) {
+	allDisabled := map[string]interface{}{"lint": map[string]interface{}{"unused_variables": false, "type_errors": false}}
+	for _, tc := range []struct {
+		code string
+		rule string
+	}{
+		{code: "local unused = 1; {}", rule: "unused_variables"},
+		{code: "local f = if std.length([]) == 0 then function(a) a else function(a, b) a; f(1, 2, 3)", rule: "type_errors"},
+		{code: "local f(a) = a; f(1, 2)", rule: "type_errors"},
+		{code: "local x = 1; x()", rule: "type_errors"},
+		{code: "local o = { a: 1 }; o.b", rule: "type_errors"},
+		{code: "local x = 1; x[0]", rule: "type_errors"},
+		{code: "local x = [1]; x['a']", rule: "type_errors"},
+		{code: "local x = {}; x[1]", rule: "type_errors"},
+		{code: "-'a'", rule: "type_errors"},
+		{code: "!1", rule: "type_errors"},
+		{code: "local f(a) = a; f(1, a=2)", rule: "type_errors"},
+		{code: "local f(a) = a; f(b=2)", rule: "type_errors"},
+		{code: "local f(a, b) = a; f(1)", rule: "type_errors"},
+		// Other messages cannot be disabled
+		{code: "local x = x; x"},
+	} {
+		t.Run(tc.code, func(t *testing.T) {
+			s, fileURI := testServerWithFile(t, nil, tc.code)
+			doc, err := s.cache.get(fileURI)
+			require.NoError(t, err)
+
+			diags := s.getLintDiags(doc)
+			require.Len(t, diags, 1)
+			rule, _ := lintRule(diags[0].Message)
+			assert.Equal(t, tc.rule, rule, diags[0].Message)
+
+			require.NoError(t, s.changeConfiguration(context.Background(), allDisabled))
+			if tc.rule == "" {
+				assert.Len(t, s.getLintDiags(doc), 1)
+			} else {
+				assert.Empty(t, s.getLintDiags(doc))
+			}
+		})
+	}
+}
+
+func TestGetEvalDiagsTopLevelFunction(t *testing.T) {
+	testCases := []struct {
+		name        string
+		fileContent string
+		settings    map[string]interface{}
+		expected    []protocol.Diagnostic
+	}{
+		{
+			name:        "parameters with defaults",
+			fileContent: `function(replicas=1) { replicas: replicas }`,
+		},
+		{
+			name:        "configured parameters",
+			fileContent: `function(cluster, replicas) { cluster: cluster, replicas: replicas }`,
+			settings: map[string]interface{}{
+				"tla_str":  map[string]interface{}{"cluster": "dev"},
+				"tla_code": map[string]interface{}{"replicas": "2"},
+			},
+		},
+		{
+			name: "missing parameter",
+			fileContent: `local defaults = { replicas: 1 };
+function(cluster, replicas=defaults.replicas) { cluster: cluster, replicas: replicas }`,
+			expected: []protocol.Diagnostic{
+				{
+					Range:    position.NewProtocolRange(1, 9, 1, 16),
+					Severity: protocol.SeverityHint,
+					Source:   "jsonnet evaluation",
+					Message:  `no value configured for top-level argument "cluster". Set it with the tla_str or tla_code settings`,
+				},
+			},
+		},
+		{
+			name:        "errors in the body are reported when parameters are missing",
+			fileContent: `function(cluster) { broken: error 'broken', cluster: cluster }`,
+			expected: []protocol.Diagnostic{
+				{
+					Range:    position.NewProtocolRange(0, 9, 0, 16),
+					Severity: protocol.SeverityHint,
+					Source:   "jsonnet evaluation",
+					Message:  `no value configured for top-level argument "cluster". Set it with the tla_str or tla_code settings`,
+				},
+				{
+					Range:    position.NewProtocolRange(0, 28, 0, 42),
+					Severity: protocol.SeverityWarning,
+					Source:   "jsonnet evaluation",
+				},
+			},
+		},
+		{
+			name:        "errors in the body are still reported",
+			fileContent: `function(cluster) { cluster: cluster, broken: error 'broken' }`,
+			settings: map[string]interface{}{
+				"tla_str": map[string]interface{}{"cluster": "dev"},
+			},
+			expected: []protocol.Diagnostic{
+				{
+					Range:    position.NewProtocolRange(0, 46, 0, 60),
+					Severity: protocol.SeverityWarning,
+					Source:   "jsonnet evaluation",
+				},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, fileURI := testServerWithFile(t, nil, tc.fileContent)
+			s.EvalDiags = true
+			if tc.settings != nil {
+				require.NoError(t, s.DidChangeConfiguration(context.Background(), &protocol.DidChangeConfigurationParams{Settings: tc.settings}))
+			}
+			doc, err := s.cache.get(fileURI)
+			require.NoError(t, err)
+
+			diags := s.getEvalDiags(doc)
+			for i := range diags {
+				if diags[i].Severity == protocol.SeverityWarning {
+					assert.Contains(t, diags[i].Message, "broken")
+					diags[i].Message = ""
+				}
+			}
+			assert.Equal(t, tc.expected, diags)
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+	"github.com/grafana/jsonnet-language-server/pkg/nodestack"
+	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/grafana/jsonnet-language-server/pkg/processing"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *server) DocumentHighlight(ctx context.Context, params *protocol.DocumentHighlightParams) ([]protocol.DocumentHighlight, error) {
+	doc, err := s.cache.get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("DocumentHighlight: %s: %w", errorRetrievingDocument, err)
+	}
+
+	if doc.ast == nil {
+		// Highlights are requested on every cursor move. Throwing an error on each request is noisy
+		log.Error("DocumentHighlight: error parsing the document")
+		return nil, nil
+	}
+
+	vm, err := s.getVM(doc.item.URI.SpanURI().Filename())
+	if err != nil {
+		return nil, utils.LogErrorf("DocumentHighlight: error creating the VM: %w", err)
+	}
+
+	return findDocumentHighlights(doc.ast, doc.item.Text, params.Position, vm), nil
+}
+
+// symbolDefinition identifies the definition of a local, a parameter or a field.
+// Locals are identified by their body, parameters and fields by their location.
+type symbolDefinition struct {
+	name     string
+	bindBody ast.Node
+	location ast.Location
+	isField  bool
+}
+
+// findDocumentHighlights returns the definition (write) and the uses (read) of the symbol under the cursor.
+// Locals and parameters are resolved from the innermost scope, fields by resolving the index.
+func findDocumentHighlights(root ast.Node, text string, pos protocol.Position, vm *jsonnet.VM) []protocol.DocumentHighlight {
+	lines := strings.Split(text, "\n")
+	location := position.PositionProtocolToAST(pos)
+	stack, err := processing.FindNodeByPosition(root, location)
+	if err != nil || stack.IsEmpty() {
+		return nil
+	}
+
+	target, highlights := findDefinitionAtPosition(stack, location, lines)
+	cursorNode := stack.Peek()
+	name := symbolName(cursorNode)
+	if target != nil {
+		name = target.name
+	}
+	if name == "" {
+		log.Debugf("DocumentHighlight: no symbol found at %v", pos)
+		return nil
+	}
+
+	// The definitions and the uses of the name are resolved in a single walk. The symbol under the cursor is
+	// only known at the end of the walk when the cursor is on a use, so they are filtered afterwards
+	var definitions, uses []symbolOccurrence
+	walkNodesWithAncestors(root, func(node ast.Node, ancestors *nodestack.NodeStack) {
+		definitions = append(definitions, symbolDefinitions(node, name, lines)...)
+
+		nameRange, ok := symbolUseRange(node, name, lines)
+		if !ok {
+			return
+		}
+		definition := resolveSymbol(ancestors, node, vm)
+		if definition == nil {
+			return
+		}
+		if node == cursorNode && target == nil {
+			target = definition
+		}
+		uses = append(uses, symbolOccurrence{
+			definition: *definition,
+			highlight:  protocol.DocumentHighlight{Range: position.RangeASTToProtocol(nameRange), Kind: protocol.Read},
+		})
+	})
+
+	if target == nil {
+		log.Debugf("DocumentHighlight: no symbol found at %v", pos)
+		return nil
+	}
+	if len(highlights) == 0 {
+		// The cursor is on a use of the symbol, the definition is highlighted if it is in the document
+		for _, definition := range definitions {
+			if definition.definition == *target {
+				highlights = append(highlights, definition.highlight)
+			}
+		}
+	}
+	for _, use := range uses {
+		if use.definition == *target {
+			highlights = append(highlights, use.highlight)
+		}
+	}
+	return highlights
+}
+
+// symbolOccurrence is the highlight of a definition or of a use of a symbol.
+type symbolOccurrence struct {
+	definition symbolDefinition
+	highlight  protocol.DocumentHighlight
+}
+
+// walkNodesWithAncestors calls fn on each node of the tree with the stack of its ancestors, from the root.
+// The stack is reused between calls, it must be cloned to be modified.
+func walkNodesWithAncestors(root ast.Node, fn func(node ast.Node, ancestors *nodestack.NodeStack)) {
+	ancestors := &nodestack.NodeStack{From: root}
+	var walk func(node ast.Node)
+	walk = func(node ast.Node) {
+		if node == nil {
+			return
+		}
+		fn(node, ancestors)
+		ancestors.Push(node)
+		for _, child := range toolutils.Children(node) {
+			walk(child)
+		}
+		ancestors.Pop()
+	}
+	walk(root)
+}
+
+// findDefinitionAtPosition checks if the cursor is on the name of a local, a parameter or a field definition.
+func findDefinitionAtPosition(stack *nodestack.NodeStack, location ast.Location, lines []string) (*symbolDefinition, []protocol.DocumentHighlight) {
+	write := func(begin ast.Location, name string) []protocol.DocumentHighlight {
+		return []protocol.DocumentHighlight{{
+			Range: position.NewProtocolRange(begin.Line-1, begin.Column-1, begin.Line-1, begin.Column-1+len(name)),
+			Kind:  protocol.Write,
+		}}
+	}
+	onName := func(begin ast.Location, name string) bool {
+		return begin.IsSet() && sourceHasPrefix(lines, begin, name) &&
+			position.InRange(location, ast.LocationRange{Begin: begin, End: ast.Location{Line: begin.Line, Column: begin.Column + len(name)}})
+	}
+
+	for _, node := range stack.Stack {
+		var binds ast.LocalBinds
+		switch node := node.(type) {
+		case *ast.Local:
+			binds = node.Binds
+		case *ast.DesugaredObject:
+			binds = node.Locals
+			for _, field := range node.Fields {
+				name, ok := field.Name.(*ast.LiteralString)
+				if ok && onName(field.LocRange.Begin, name.Value) {
+					return &symbolDefinition{name: name.Value, location: field.LocRange.Begin, isField: true}, write(field.LocRange.Begin, name.Value)
+				}
+			}
+		case *ast.Function:
+			for _, param := range node.Parameters {
+				if onName(param.LocRange.Begin, string(param.Name)) {
+					return &symbolDefinition{name: string(param.Name), location: param.LocRange.Begin}, write(param.LocRange.Begin, string(param.Name))
+				}
+			}
+		}
+		for _, bind := range binds {
+			begin := bindNameLocation(bind)
+			if onName(begin, string(bind.Variable)) {
+				return &symbolDefinition{name: string(bind.Variable), bindBody: bind.Body}, write(begin, string(bind.Variable))
+			}
+		}
+	}
+	return nil, nil
+}
+
+// symbolDefinitions returns the locals, parameters and fields with the given name defined by a node.
+func symbolDefinitions(node ast.Node, name string, lines []string) []symbolOccurrence {
+	var occurrences []symbolOccurrence
+	add := func(begin ast.Location, definition symbolDefinition) {
+		if begin.IsSet() && sourceHasPrefix(lines, begin, name) {
+			occurrences = append(occurrences, symbolOccurrence{
+				definition: definition,
+				highlight: protocol.DocumentHighlight{
+					Range: position.NewProtocolRange(begin.Line-1, begin.Column-1, begin.Line-1, begin.Column-1+len(name)),
+					Kind:  protocol.Write,
+				},
+			})
+		}
+	}
+
+	var binds ast.LocalBinds
+	switch node := node.(type) {
+	case *ast.Local:
+		binds = node.Binds
+	case *ast.DesugaredObject:
+		binds = node.Locals
+		for _, field := range node.Fields {
+			if fieldName, ok := field.Name.(*ast.LiteralString); ok && fieldName.Value == name {
+				add(field.LocRange.Begin, symbolDefinition{name: name, location: field.LocRange.Begin, isField: true})
+			}
+		}
+	case *ast.Function:
+		for _, param := range node.Parameters {
+			if string(param.Name) == name {
+				add(param.LocRange.Begin, symbolDefinition{name: name, location: param.LocRange.Begin})
+			}
+		}
+	}
+	for _, bind := range binds {
+		if string(bind.Variable) == name {
+			add(bindNameLocation(bind), symbolDefinition{name: name, bindBody: bind.Body})
+		}
+	}
+	return occurrences
+}
+
+// symbolName returns the name of a variable or of the last index of an index chain.
+func symbolName(node ast.Node) string {
+	var index ast.Node
+	switch node := node.(type) {
+	case *ast.Var:
+		return string(node.Id)
+	case *ast.Index:
+		index = node.Index
+	case *ast.SuperIndex:
+		index = node.Index
+	}
+	if str, ok := index.(*ast.LiteralString); ok {
+		return str.Value
+	}
+	return ""
+}
+
+// findVarDefinition finds the local or the parameter defining a variable, from the innermost scope.
+func findVarDefinition(ancestors *nodestack.NodeStack, id ast.Identifier) *symbolDefinition {
+	for i := len(ancestors.Stack) - 1; i >= 0; i-- {
+		var binds ast.LocalBinds
+		switch node := ancestors.Stack[i].(type) {
+		case *ast.Local:
+			binds = node.Binds
+		case *ast.DesugaredObject:
+			binds = node.Locals
+		case *ast.Function:
+			for _, param := range node.Parameters {
+				if param.Name == id {
+					return &symbolDefinition{name: string(id), location: param.LocRange.Begin}
+				}
+			}
+		}
+		for _, bind := range binds {
+			if bind.Variable == id {
+				return &symbolDefinition{name: string(id), bindBody: bind.Body}
+			}
+		}
+	}
+	return nil
+}
+
+// resolveSymbol finds the definition of a variable or of the last index of an index chain.
+// The stack holds the ancestors of the node, it is not modified.
+func resolveSymbol(ancestors *nodestack.NodeStack, node ast.Node, vm *jsonnet.VM) *symbolDefinition {
+	switch node := node.(type) {
+	case *ast.Var:
+		return findVarDefinition(ancestors, node.Id)
+	case *ast.Index, *ast.SuperIndex:
+		indexList := nodestack.NewNodeStack(node).BuildIndexList()
+		if len(indexList) < 2 {
+			return nil
+		}
+		ranges, err := processing.FindRangesFromIndexList(ancestors.Clone(), indexList, vm)
+		if err != nil || len(ranges) == 0 {
+			log.Debugf("DocumentHighlight: unable to resolve %v: %v", indexList, err)
+			return nil
+		}
+		if ranges[0].Filename != node.Loc().FileName {
+			return nil
+		}
+		return &symbolDefinition{name: indexList[len(indexList)-1], location: ranges[0].SelectionRange.Begin, isField: true}
+	}
+	return nil
+}
+
+// symbolUseRange returns the range of the name of a variable or of the last index of an index chain.
+func symbolUseRange(node ast.Node, name string, lines []string) (ast.LocationRange, bool) {
+	loc := *node.Loc()
+	if !loc.IsSet() {
+		return ast.LocationRange{}, false
+	}
+
+	var index ast.Node
+	switch node := node.(type) {
+	case *ast.Var:
+		return loc, string(node.Id) == name
+	case *ast.Index:
+		index = node.Index
+	case *ast.SuperIndex:
+		index = node.Index
+	default:
+		return ast.LocationRange{}, false
+	}
+
+	str, ok := index.(*ast.LiteralString)
+	if !ok || str.Value != name {
+		return ast.LocationRange{}, false
+	}
+	if str.Loc().IsSet() {
+		// Bracket access: `obj['name']`
+		return *str.Loc(), true
+	}
+
+	// Dot access: the name is at the end of the index's range, or right after `super.`. The range of a SuperIndex
+	// may have been extended to the name by processing.FindNodeByPosition
+	begin := ast.Location{Line: loc.End.Line, Column: loc.End.Column - len(name)}
+	if _, isSuper := node.(*ast.SuperIndex); isSuper {
+		begin = ast.Location{Line: loc.Begin.Line, Column: loc.Begin.Column + len("super.")}
+	}
+	if !sourceHasPrefix(lines, begin, name) {
+		return ast.LocationRange{}, false
+	}
+	return ast.LocationRange{Begin: begin, End: ast.Location{Line: begin.Line, Column: begin.Column + len(name)}}, true
+}
+
+// bindNameLocation returns the location of the name of a local bind.
+// Binds of functions (`local f(x) = x`) do not have a location but their function starts with the name.
+func bindNameLocation(bind ast.LocalBind) ast.Location {
+	if bind.LocRange.Begin.IsSet() {
+		return bind.LocRange.Begin
+	}
+	return bind.Body.Loc().Begin
+}
+
+func sourceHasPrefix(lines []string, loc ast.Location, prefix string) bool {
+	if loc.Line < 1 || loc.Line > len(lines) || loc.Column < 1 || loc.Column-1 > len(lines[loc.Line-1]) {
+		return false
+	}
+	return strings.HasPrefix(lines[loc.Line-1][loc.Column-1:], prefix)
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentHighlight(t *testing.T) {
+	write := func(line, start, end int) protocol.DocumentHighlight {
+		return protocol.DocumentHighlight{Range: position.NewProtocolRange(line, start, line, end), Kind: protocol.Write}
+	}
+	read := func(line, start, end int) protocol.DocumentHighlight {
+		return protocol.DocumentHighlight{Range: position.NewProtocolRange(line, start, line, end), Kind: protocol.Read}
+	}
+
+	testCases := []struct {
+		name     string
+		position protocol.Position
+		expected []protocol.DocumentHighlight
+	}{
+		{
+			name:     "local definition",
+			position: protocol.Position{Line: 0, Character: 6},
+			expected: []protocol.DocumentHighlight{write(0, 6, 7), read(1, 17, 18), read(3, 7, 8)},
+		},
+		{
+			name:     "local use",
+			position: protocol.Position{Line: 3, Character: 7},
+			expected: []protocol.DocumentHighlight{write(0, 6, 7), read(1, 17, 18), read(3, 7, 8)},
+		},
+		{
+			name:     "shadowed local",
+			position: protocol.Position{Line: 7, Character: 7},
+			expected: []protocol.DocumentHighlight{write(6, 10, 11), read(7, 7, 8)},
+		},
+		{
+			name:     "parameter",
+			position: protocol.Position{Line: 1, Character: 13},
+			expected: []protocol.DocumentHighlight{write(1, 8, 9), read(1, 13, 14)},
+		},
+		{
+			name:     "local function",
+			position: protocol.Position{Line: 4, Character: 18},
+			expected: []protocol.DocumentHighlight{write(1, 6, 7), read(4, 18, 19)},
+		},
+		{
+			name:     "field use",
+			position: protocol.Position{Line: 4, Character: 13},
+			expected: []protocol.DocumentHighlight{write(3, 2, 5), read(4, 12, 15), read(9, 9, 12), read(11, 13, 16)},
+		},
+		{
+			name:     "field definition",
+			position: protocol.Position{Line: 3, Character: 3},
+			expected: []protocol.DocumentHighlight{write(3, 2, 5), read(4, 12, 15), read(9, 9, 12), read(11, 13, 16)},
+		},
+		{
+			name:     "field use through super",
+			position: protocol.Position{Line: 11, Character: 14},
+			expected: []protocol.DocumentHighlight{write(3, 2, 5), read(4, 12, 15), read(9, 9, 12), read(11, 13, 16)},
+		},
+		{
+			name:     "no symbol",
+			position: protocol.Position{Line: 2, Character: 0},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := testServer(t, nil)
+			uri := serverOpenTestFile(t, s, "./testdata/highlight.jsonnet")
+
+			highlights, err := s.DocumentHighlight(context.Background(), &protocol.DocumentHighlightParams{
+				TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+					TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+					Position:     tc.position,
+				},
+			})
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tc.expected, highlights)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// DocumentLink returns a link to the imported file for every import and importstr.
+// Imports that cannot be resolved are reported by the diagnostics instead.
+func (s *server) DocumentLink(ctx context.Context, params *protocol.DocumentLinkParams) ([]protocol.DocumentLink, error) {
+	doc, err := s.cache.get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("DocumentLink: %s: %w", errorRetrievingDocument, err)
+	}
+
+	if doc.ast == nil {
+		log.Error("DocumentLink: error parsing the document")
+		return nil, nil
+	}
+
+	filename := params.TextDocument.URI.SpanURI().Filename()
+	vm, err := s.getVM(filename)
+	if err != nil {
+		return nil, utils.LogErrorf("DocumentLink: error creating the VM: %w", err)
+	}
+
+	links := []protocol.DocumentLink{}
+	for _, importPath := range findImportPaths(doc.ast) {
+		foundAt, err := vm.ResolveImport(filename, importPath.Value)
+		if err != nil {
+			log.Debugf("DocumentLink: unable to resolve import %q: %v", importPath.Value, err)
+			continue
+		}
+		if foundAt, err = filepath.Abs(foundAt); err != nil {
+			return nil, err
+		}
+		links = append(links, protocol.DocumentLink{
+			Range:  position.RangeASTToProtocol(*importPath.Loc()),
+			Target: string(protocol.URIFromPath(foundAt)),
+		})
+	}
+	return links, nil
+}
+
+// findImportPaths returns the path literals of all import and importstr expressions, in order of appearance.
+func findImportPaths(root ast.Node) []*ast.LiteralString {
+	var paths []*ast.LiteralString
+	walkNodes(root, func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.Import:
+			paths = append(paths, node.File)
+		case *ast.ImportStr:
+			paths = append(paths, node.File)
+		}
+	})
+	sort.SliceStable(paths, func(i, j int) bool {
+		return ast.LocationBefore(paths[i].Loc().Begin, paths[j].Loc().Begin)
+	})
+	return paths
+}
+
+// importSearchPaths returns the directories that are searched for the imports of the given file, in order.
+func (s *server) importSearchPaths(path string) []string {
+	searchPaths := []string{filepath.Dir(path)}
+	if s.getJPaths == nil {
+		return searchPaths
+	}
+
+	// The right-most jpath wins
+	jpaths := s.getJPaths(path)
+	for i := len(jpaths) - 1; i >= 0; i-- {
+		if jpaths[i] != searchPaths[0] {
+			searchPaths = append(searchPaths, jpaths[i])
+		}
+	}
+	return searchPaths
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentLink(t *testing.T) {
+	s := testServer(t, nil)
+	uri := serverOpenTestFile(t, s, "./testdata/document-links.jsonnet")
+
+	links, err := s.DocumentLink(context.Background(), &protocol.DocumentLinkParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []protocol.DocumentLink{
+		{
+			Range:  position.NewProtocolRange(0, 19, 0, 46),
+			Target: string(absUri(t, "./testdata/goto-basic-object.jsonnet")),
+		},
+		{
+			Range:  position.NewProtocolRange(1, 22, 1, 48),
+			Target: string(absUri(t, "./testdata/test_basic_lib.libsonnet")),
+		},
+	}, links)
+}
+
+func TestGetImportDiags(t *testing.T) {
+	s := testServer(t, nil)
+	uri := serverOpenTestFile(t, s, "./testdata/document-links.jsonnet")
+	doc, err := s.cache.get(uri)
+	require.NoError(t, err)
+
+	testdata, err := filepath.Abs("testdata")
+	require.NoError(t, err)
+	assert.Equal(t, []protocol.Diagnostic{
+		{
+			Range:    position.NewProtocolRange(2, 23, 2, 42),
+			Severity: protocol.SeverityError,
+			Source:   "jsonnet imports",
+			Message:  fmt.Sprintf(`unable to resolve import "missing.libsonnet", searched in: %s`, testdata),
+		},
+	}, s.getImportDiags(doc))
+}
+
+func TestSuggestImport(t *testing.T) {
+	testdata, err := filepath.Abs("testdata")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		importPath string
+		expected   string
+	}{
+		{name: "typo", importPath: "test_basic_lib.libsonet", expected: "test_basic_lib.libsonnet"},
+		{name: "wrong extension", importPath: "goto-basic-object.libsonnet", expected: "goto-basic-object.jsonnet"},
+		{name: "in directory", importPath: "../testdata/highlite.jsonnet", expected: "../testdata/highlight.jsonnet"},
+		{name: "nothing close", importPath: "missing.libsonnet", expected: ""},
+		{name: "missing directory", importPath: "missing/highlight.jsonnet", expected: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, suggestImport([]string{testdata}, tc.importPath))
+		})
+	}
+}
@@ -4,25 +4,50 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"reflect"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/jsonnet-language-server/pkg/nodestack"
 	"github.com/grafana/jsonnet-language-server/pkg/position"
 	"github.com/grafana/jsonnet-language-server/pkg/processing"
 	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/jsonrpc2"
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
 )
 
+// commands are the commands handled by ExecuteCommand, they are advertised to the client on initialization.
+var commands = []string{
+	"jsonnet.evalItem",
+	"jsonnet.evalFile",
+	"jsonnet.evalExpression",
+	"tanka.showManifests",
+	"tanka.selectEnvironment",
+}
+
 func (s *server) ExecuteCommand(ctx context.Context, params *protocol.ExecuteCommandParams) (interface{}, error) {
 	switch params.Command {
 	case "jsonnet.evalItem":
-		// WIP
 		return s.evalItem(ctx, params)
 	case "jsonnet.evalFile":
-		params.Arguments = append(params.Arguments, json.RawMessage("\"\""))
+		if len(params.Arguments) == 0 {
+			return nil, fmt.Errorf("expected 1 or 2 arguments, got 0")
+		}
+		// The file is evaluated as the expression `main`, options are passed through
+		params.Arguments = append([]json.RawMessage{params.Arguments[0], json.RawMessage(`"main"`)}, params.Arguments[1:]...)
 		return s.evalExpression(ctx, params)
 	case "jsonnet.evalExpression":
 		return s.evalExpression(ctx, params)
+	case "tanka.showManifests":
+		return s.showManifests(ctx, params)
+	case "tanka.selectEnvironment":
+		return s.selectEnvironment(ctx, params)
 	}
 
 	return nil, fmt.Errorf("unknown command: %s", params.Command)
@@ -48,7 +73,12 @@ func (s *server) evalItem(ctx context.Context, params *protocol.ExecuteCommandPa
 		return nil, utils.LogErrorf("evalItem: %s: %w", errorRetrievingDocument, err)
 	}
 
-	stack, err := processing.FindNodeByPosition(doc.ast, position.PositionProtocolToAST(p))
+	if doc.ast == nil {
+		return nil, fmt.Errorf("evalItem: error parsing the document: %v", doc.err)
+	}
+
+	location := position.PositionProtocolToAST(p)
+	stack, err := processing.FindNodeByPosition(doc.ast, location)
 	if err != nil {
 		return nil, err
 	}
@@ -57,40 +87,446 @@ func (s *server) evalItem(ctx context.Context, params *protocol.ExecuteCommandPa
 		return nil, fmt.Errorf("no node found at position %v", p)
 	}
 
-	log.Infof("fileName: %s", fileName)
-	log.Infof("position: %+v", p)
+	settings := s.evalSettingsFor(fileName)
+	script, err := evalItemScript(doc.ast, doc.item.Text, stack, location, settings)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("evalItem: evaluating %s at %+v", fileName, p)
+
+	vm, err := s.getVM(fileName)
+	if err != nil {
+		return nil, err
+	}
+	setTopLevelArgs(vm, settings)
+	return vm.EvaluateAnonymousSnippet(fileName, script)
+}
+
+// evalItemFieldName is the name of the hidden field added to an object to evaluate one of its locals.
+const evalItemFieldName = "jsonnet-language-server:evalItem"
+
+// evalItemScript returns a script evaluating the node at the given location in the context of its file.
+// On a local (its name or a variable referencing it), the script evaluates to the local's value.
+// Otherwise, it evaluates to the innermost field containing the location, indexed from the root object.
+// Files evaluating to a function are called with the top-level arguments of the settings, see setTopLevelArgs.
+func evalItemScript(root ast.Node, text string, stack *nodestack.NodeStack, location ast.Location, settings evalSettings) (string, error) {
+	lines := strings.Split(text, "\n")
+
+	mainCall := ""
+	if function, ok := topLevelExpression(root).(*ast.Function); ok {
+		for _, param := range function.Parameters {
+			_, isStr := settings.TLAStr[string(param.Name)]
+			_, isCode := settings.TLACode[string(param.Name)]
+			if param.DefaultArg == nil && !isStr && !isCode {
+				return "", fmt.Errorf("unable to evaluate the file: its top-level function has no value for the parameter %s, set it with the tla_str or tla_code settings", param.Name)
+			}
+		}
+		args, err := topLevelArgsCall(settings)
+		if err != nil {
+			return "", err
+		}
+		mainCall = "(" + args + ")"
+	}
+
+	var bind *ast.LocalBind
+	if definition, _ := findDefinitionAtPosition(stack, location, lines); definition != nil && definition.bindBody != nil {
+		walkNodes(root, func(node ast.Node) {
+			var binds ast.LocalBinds
+			switch node := node.(type) {
+			case *ast.Local:
+				binds = node.Binds
+			case *ast.DesugaredObject:
+				binds = node.Locals
+			}
+			for i := range binds {
+				if binds[i].Body == definition.bindBody {
+					bind = &binds[i]
+				}
+			}
+		})
+	} else if v, ok := stack.Peek().(*ast.Var); ok {
+		bind = processing.FindBindByIdViaStack(stack, v.Id)
+	}
+
+	if bind == nil {
+		path, _, err := findEvalPath(root, location)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("local main = (\n%s\n)%s;\nmain%s", text, mainCall, indexPath(path)), nil
+	}
+
+	// Locals are evaluated by replacing the body of their local with their name,
+	// or by adding a hidden field with their value to their object
+	path, container, err := findEvalPath(root, bindNameLocation(*bind))
+	if err != nil {
+		return "", err
+	}
+	switch container := container.(type) {
+	case *ast.Local:
+		body := container.Body.Loc()
+		if !body.IsSet() {
+			break
+		}
+		start := lineOffset(lines, body.Begin.Line-1) + body.Begin.Column - 1
+		end := lineOffset(lines, body.End.Line-1) + body.End.Column - 1
+		modified := text[:start] + string(bind.Variable) + text[end:]
+		return fmt.Sprintf("local main = (\n%s\n)%s;\nmain%s", modified, mainCall, indexPath(path)), nil
+	case *ast.DesugaredObject:
+		begin := container.Loc().Begin
+		if !begin.IsSet() {
+			break
+		}
+		start := lineOffset(lines, begin.Line-1) + begin.Column
+		fieldName, _ := json.Marshal(evalItemFieldName)
+		modified := fmt.Sprintf("%s%s:: %s, %s", text[:start], fieldName, bind.Variable, text[start:])
+		return fmt.Sprintf("local main = (\n%s\n)%s;\nmain%s", modified, mainCall, indexPath(append(path, evalItemFieldName))), nil
+	}
+	return "", fmt.Errorf("unable to evaluate local %s: only locals that are not within functions or arrays can be evaluated", bind.Variable)
+}
+
+// findEvalPath descends from the root towards the location through locals, object merges and object fields.
+// It returns the names of the fields leading to the location and the innermost node that was reached.
+func findEvalPath(root ast.Node, location ast.Location) ([]string, ast.Node, error) {
+	var path []string
+	node := root
+	for {
+		switch current := node.(type) {
+		case *ast.Local:
+			if !position.InRange(location, *current.Body.Loc()) {
+				return path, current, nil
+			}
+			node = current.Body
+			continue
+		case *ast.Function:
+			// Only the function of files evaluating to a function is called, see evalItemScript
+			if current == topLevelExpression(root) && position.InRange(location, *current.Body.Loc()) {
+				node = current.Body
+				continue
+			}
+		case *ast.Binary:
+			if current.Op == ast.BopPlus && position.InRange(location, *current.Left.Loc()) {
+				node = current.Left
+				continue
+			}
+			if current.Op == ast.BopPlus && position.InRange(location, *current.Right.Loc()) {
+				node = current.Right
+				continue
+			}
+		case *ast.DesugaredObject:
+			for _, field := range current.Fields {
+				if !position.InRange(location, field.LocRange) {
+					continue
+				}
+				name, ok := field.Name.(*ast.LiteralString)
+				if !ok {
+					return nil, nil, fmt.Errorf("unable to evaluate the field at %v: computed field names are not supported", location)
+				}
+				path = append(path, name.Value)
+				node = field.Body
+				if !position.InRange(location, *field.Body.Loc()) {
+					// The location is on the field's name
+					return path, node, nil
+				}
+				break
+			}
+			if node != current {
+				continue
+			}
+		}
+		return path, node, nil
+	}
+}
 
-	node := stack.Pop()
+// indexPath returns the Jsonnet indexes of a field path, e.g. `["a"][0]`.
+// Field names are quoted as JSON strings, which are valid Jsonnet strings.
+func indexPath[T any](path []T) string {
+	var indexes strings.Builder
+	for _, name := range path {
+		quoted, _ := json.Marshal(name)
+		fmt.Fprintf(&indexes, "[%s]", quoted)
+	}
+	return indexes.String()
+}
 
-	return nil, fmt.Errorf("%v: %+v", reflect.TypeOf(node), node)
+// evalExpressionOptions is the optional third argument of jsonnet.evalExpression.
+// Top-level arguments are passed to the evaluated file, if it is a function.
+type evalExpressionOptions struct {
+	ExtVars map[string]string `json:"ext_vars"`
+	ExtCode map[string]string `json:"ext_code"`
+	TLAVars map[string]string `json:"tla_vars"`
+	TLACode map[string]string `json:"tla_code"`
+	// Output is the format of the result, one of the evalOutput* constants. Defaults to JSON
+	Output string `json:"output"`
+	// Environment is the name of a Tanka inline environment of the file, that `main` is bound to
+	Environment string `json:"environment"`
 }
 
+const (
+	evalOutputJSON = "json"
+	// evalOutputYAML converts the JSON result to YAML
+	evalOutputYAML = "yaml"
+	// evalOutputYAMLStream expects an array and returns its elements as YAML documents, like `jsonnet -y`
+	evalOutputYAMLStream = "yaml_stream"
+	// evalOutputString expects a string and returns it as is, like `jsonnet -S`
+	evalOutputString = "string"
+	// evalOutputMulti expects an object and returns a map of file names to JSON contents, like `jsonnet -m`
+	evalOutputMulti = "multi"
+)
+
+const (
+	// evalTLAExtPrefix prefixes the ext vars that carry the top-level arguments of the evaluated file
+	evalTLAExtPrefix = "jsonnet-language-server:tla:"
+)
+
+var (
+	identifierRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	fieldPathRegexp  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)*$`)
+)
+
+// evalExpression evaluates an expression in which `main` is bound to the given file.
+// The expression is either a field path array (`["a", 0]`) or a Jsonnet expression (`main.a[0]`).
+// For compatibility, a dotted field path (`a.b`) that does not start with `main` or `std` is indexed from `main`.
 func (s *server) evalExpression(ctx context.Context, params *protocol.ExecuteCommandParams) (interface{}, error) {
 	args := params.Arguments
-	if len(args) != 2 {
-		return nil, fmt.Errorf("expected 2 arguments, got %d", len(args))
+	if len(args) != 2 && len(args) != 3 {
+		return nil, fmt.Errorf("expected 2 or 3 arguments, got %d", len(args))
 	}
 
 	var fileName string
 	if err := json.Unmarshal(args[0], &fileName); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal file name: %v", err)
 	}
-	var expression string
+	fileName, err := filepath.Abs(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		expression string
+		path       []interface{}
+	)
 	if err := json.Unmarshal(args[1], &expression); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal expression: %v", err)
+		if err := json.Unmarshal(args[1], &path); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal expression, expected a string or a field path array: %v", err)
+		}
+	}
+	if first := strings.Split(expression, ".")[0]; fieldPathRegexp.MatchString(expression) && first != "main" && first != "std" {
+		for _, name := range strings.Split(expression, ".") {
+			path = append(path, name)
+		}
+		expression = ""
+	}
+	if expression == "" {
+		for _, index := range path {
+			switch index.(type) {
+			case string, float64:
+			default:
+				return nil, fmt.Errorf("unsupported field path element %v, expected a string or a number", index)
+			}
+		}
+		expression = "main" + indexPath(path)
+	}
+
+	var opts evalExpressionOptions
+	if len(args) == 3 {
+		if err := json.Unmarshal(args[2], &opts); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal options: %v", err)
+		}
+	}
+
+	switch opts.Output {
+	case "", evalOutputJSON, evalOutputYAML, evalOutputYAMLStream, evalOutputString, evalOutputMulti:
+	default:
+		return nil, fmt.Errorf("unsupported output %q, expected one of: %s", opts.Output, strings.Join([]string{evalOutputJSON, evalOutputYAML, evalOutputYAMLStream, evalOutputString, evalOutputMulti}, ", "))
 	}
 
-	// TODO: Replace this stuff with Tanka's `eval` code
 	vm, err := s.getVM(fileName)
 	if err != nil {
 		return nil, err
 	}
+	// Configured top-level arguments are passed to the file rather than to the expression
+	settings := s.evalSettingsFor(fileName).merge(evalSettings{ExtVars: opts.ExtVars, ExtCode: opts.ExtCode, TLAStr: opts.TLAVars, TLACode: opts.TLACode})
+	resetEvalSettings(vm, evalSettings{ExtVars: settings.ExtVars, ExtCode: settings.ExtCode})
 
-	script := fmt.Sprintf("local main = (import '%s');\nmain", fileName)
-	if expression != "" {
-		script += "." + expression
+	// `main` is passed as a top-level argument of the expression. The file's own top-level arguments
+	// are carried by ext vars, so that no user input is spliced into the code
+	quotedFileName, _ := json.Marshal(fileName)
+	mainCode := fmt.Sprintf("import %s", quotedFileName)
+	if (len(settings.TLAStr) > 0 || len(settings.TLACode) > 0) && isTopLevelFunction(fileName) {
+		args, err := topLevelArgsCall(settings)
+		if err != nil {
+			return nil, err
+		}
+		setTopLevelArgs(vm, settings)
+		mainCode = fmt.Sprintf("(%s)(%s)", mainCode, args)
+	}
+	if opts.Environment != "" {
+		mainCode = inlineEnvironmentCode(mainCode, opts.Environment)
+	}
+	vm.TLACode("main", mainCode)
 
+	errFormatter := &rawErrorFormatter{ErrorFormatter: vm.ErrorFormatter}
+	vm.ErrorFormatter = errFormatter
+	result, err := evaluateWithOutput(vm, fileName, "function(main)\n"+expression, opts.Output)
+	if err != nil && errFormatter.err != nil {
+		return nil, newEvaluationError(errFormatter.err).jsonrpcError()
 	}
+	return result, err
+}
 
-	return vm.EvaluateAnonymousSnippet(fileName, script)
+// isTopLevelFunction returns whether the file saved on disk, which is the one imported, evaluates to a function.
+// Like jsonnet, top-level arguments are only passed to files evaluating to a function.
+func isTopLevelFunction(filename string) bool {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return false
+	}
+	root, err := jsonnet.SnippetToAST(filename, string(content))
+	if err != nil {
+		return false
+	}
+	_, ok := topLevelExpression(root).(*ast.Function)
+	return ok
+}
+
+// setTopLevelArgs sets the ext vars carrying the top-level arguments of the settings to the evaluated file.
+func setTopLevelArgs(vm *jsonnet.VM, settings evalSettings) {
+	for name, value := range settings.TLAStr {
+		vm.ExtVar(evalTLAExtPrefix+name, value)
+	}
+	for name, value := range settings.TLACode {
+		vm.ExtCode(evalTLAExtPrefix+name, value)
+	}
+}
+
+// topLevelArgsCall returns the arguments calling the function of a file with the top-level arguments of the settings,
+// such as `name=std.extVar("jsonnet-language-server:tla:name")`. The values are carried by ext vars, see setTopLevelArgs.
+func topLevelArgsCall(settings evalSettings) (string, error) {
+	var tlas []string
+	for name := range settings.TLAStr {
+		tlas = append(tlas, name)
+	}
+	for name := range settings.TLACode {
+		tlas = append(tlas, name)
+	}
+	sort.Strings(tlas)
+
+	tlaArgs := make([]string, 0, len(tlas))
+	for _, name := range tlas {
+		if !identifierRegexp.MatchString(name) {
+			return "", fmt.Errorf("invalid top-level argument name %q", name)
+		}
+		quotedExtName, _ := json.Marshal(evalTLAExtPrefix + name)
+		tlaArgs = append(tlaArgs, fmt.Sprintf("%s=std.extVar(%s)", name, quotedExtName))
+	}
+	return strings.Join(tlaArgs, ", "), nil
+}
+
+// evaluateWithOutput evaluates a snippet and formats its result.
+func evaluateWithOutput(vm *jsonnet.VM, fileName, snippet, output string) (interface{}, error) {
+	switch output {
+	case evalOutputYAML:
+		result, err := vm.EvaluateAnonymousSnippet(fileName, snippet)
+		if err != nil {
+			return nil, err
+		}
+		converted, err := yaml.JSONToYAML([]byte(result))
+		return string(converted), err
+	case evalOutputYAMLStream:
+		docs, err := vm.EvaluateAnonymousSnippetStream(fileName, snippet)
+		if err != nil {
+			return nil, err
+		}
+		var stream strings.Builder
+		for _, doc := range docs {
+			converted, err := yaml.JSONToYAML([]byte(doc))
+			if err != nil {
+				return nil, err
+			}
+			stream.WriteString("---\n")
+			stream.Write(converted)
+		}
+		return stream.String(), nil
+	case evalOutputString:
+		vm.StringOutput = true
+		return vm.EvaluateAnonymousSnippet(fileName, snippet)
+	case evalOutputMulti:
+		return vm.EvaluateAnonymousSnippetMulti(fileName, snippet)
+	}
+	return vm.EvaluateAnonymousSnippet(fileName, snippet)
+}
+
+// rawErrorFormatter keeps the last error formatted by the VM, which only returns the formatted message.
+type rawErrorFormatter struct {
+	jsonnet.ErrorFormatter
+	err error
+}
+
+func (f *rawErrorFormatter) Format(err error) string {
+	f.err = err
+	return f.ErrorFormatter.Format(err)
+}
+
+// evaluationError is a Jsonnet evaluation error with the locations it was raised from, innermost first.
+type evaluationError struct {
+	Message   string              `json:"message"`
+	Locations []protocol.Location `json:"locations"`
+}
+
+func newEvaluationError(err error) *evaluationError {
+	evalErr := &evaluationError{Message: err.Error()}
+	var locations []ast.LocationRange
+	switch err := err.(type) {
+	case jsonnet.RuntimeError:
+		evalErr.Message = err.Msg
+		// The stack trace starts with the outermost frame
+		for i := len(err.StackTrace) - 1; i >= 0; i-- {
+			locations = append(locations, err.StackTrace[i].Loc)
+		}
+	case interface{ Loc() ast.LocationRange }:
+		locations = append(locations, err.Loc())
+	}
+
+	for _, loc := range locations {
+		// The evaluated expression has no file name and top-level arguments are named `<...>`
+		if !loc.IsSet() || loc.FileName == "" || strings.HasPrefix(loc.FileName, "<") {
+			continue
+		}
+		evalErr.Locations = append(evalErr.Locations, protocol.Location{
+			URI:   protocol.URIFromPath(loc.FileName),
+			Range: position.RangeASTToProtocol(loc),
+		})
+	}
+	return evalErr
+}
+
+func (e *evaluationError) Error() string {
+	var msg strings.Builder
+	msg.WriteString(e.Message)
+	for _, loc := range e.Locations {
+		fmt.Fprintf(&msg, "\n\tat %s:%d:%d", loc.URI.SpanURI().Filename(), loc.Range.Start.Line+1, loc.Range.Start.Character+1)
+	}
+	return msg.String()
+}
+
+// jsonrpcUnknownErrorCode is the code of jsonrpc2.ErrUnknown, for errors without a specific code.
+const jsonrpcUnknownErrorCode = -32001
+
+// jsonrpcError returns the error sent to the client, with the error as its data so that clients get its locations.
+// The protocol library only creates errors without data, the error is decoded from a response instead.
+func (e *evaluationError) jsonrpcError() error {
+	response, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      0,
+		"error":   map[string]interface{}{"code": jsonrpcUnknownErrorCode, "message": e.Error(), "data": e},
+	})
+	if err != nil {
+		return e
+	}
+	msg, err := jsonrpc2.DecodeMessage(response)
+	if err != nil {
+		return e
+	}
+	return msg.(*jsonrpc2.Response).Err()
 }
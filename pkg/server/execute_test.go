@@ -0,0 +1,356 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jdbaldry/go-language-server-protocol/jsonrpc2"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuteCommands(t *testing.T) {
+	s := NewServer("jsonnet-language-server", "dev", nil).WithStaticVM([]string{})
+	result, err := s.Initialize(context.Background(), &protocol.ParamInitialize{})
+	require.NoError(t, err)
+	require.NotEmpty(t, result.Capabilities.ExecuteCommandProvider.Commands)
+
+	for _, command := range result.Capabilities.ExecuteCommandProvider.Commands {
+		t.Run(command, func(t *testing.T) {
+			_, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{Command: command})
+			require.Error(t, err, "the command has no arguments")
+			assert.NotContains(t, err.Error(), "unknown command")
+		})
+	}
+}
+
+func TestEvalItem(t *testing.T) {
+	testCases := []struct {
+		name        string
+		position    protocol.Position
+		expected    string
+		expectedErr string
+	}{
+		{name: "top-level local", position: protocol.Position{Line: 0, Character: 7}, expected: "3"},
+		{name: "object local", position: protocol.Position{Line: 3, Character: 10}, expected: "6"},
+		{name: "variable referencing an object local", position: protocol.Position{Line: 5, Character: 16}, expected: "6"},
+		{name: "field name", position: protocol.Position{Line: 5, Character: 5}, expected: "6"},
+		{name: "quoted field name", position: protocol.Position{Line: 6, Character: 8}, expected: `"app-container"`},
+		{name: "local in a field", position: protocol.Position{Line: 7, Character: 20}, expected: "8080"},
+		{name: "variable referencing a local in a field", position: protocol.Position{Line: 7, Character: 42}, expected: "8080"},
+		{name: "field of a merged object", position: protocol.Position{Line: 11, Character: 3}, expected: "7"},
+		{
+			name:        "local in a function",
+			position:    protocol.Position{Line: 9, Character: 20},
+			expectedErr: "unable to evaluate local twice: only locals that are not within functions or arrays can be evaluated",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := testServer(t, nil)
+			filename, err := filepath.Abs("./testdata/eval-item.jsonnet")
+			require.NoError(t, err)
+			serverOpenTestFile(t, s, filename)
+
+			fileArg, err := json.Marshal(filename)
+			require.NoError(t, err)
+			positionArg, err := json.Marshal(tc.position)
+			require.NoError(t, err)
+			result, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+				Command:   "jsonnet.evalItem",
+				Arguments: []json.RawMessage{fileArg, positionArg},
+			})
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.expected, result.(string))
+		})
+	}
+}
+
+func TestEvalItemTopLevelFunction(t *testing.T) {
+	testCases := []struct {
+		name        string
+		settings    map[string]interface{}
+		position    protocol.Position
+		expected    string
+		expectedErr string
+	}{
+		{name: "field", settings: map[string]interface{}{"tla_str": map[string]interface{}{"cluster": "dev"}}, position: protocol.Position{Line: 4, Character: 5}, expected: "1"},
+		{name: "local", settings: map[string]interface{}{"tla_str": map[string]interface{}{"cluster": "dev"}}, position: protocol.Position{Line: 1, Character: 9}, expected: `"app-dev"`},
+		{name: "code argument", settings: map[string]interface{}{"tla_str": map[string]interface{}{"cluster": "dev"}, "tla_code": map[string]interface{}{"replicas": "3"}}, position: protocol.Position{Line: 4, Character: 5}, expected: "3"},
+		{
+			name:        "missing argument",
+			position:    protocol.Position{Line: 4, Character: 5},
+			expectedErr: "unable to evaluate the file: its top-level function has no value for the parameter cluster, set it with the tla_str or tla_code settings",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := testServer(t, nil)
+			if tc.settings != nil {
+				require.NoError(t, s.changeConfiguration(context.Background(), tc.settings))
+			}
+			filename, err := filepath.Abs("./testdata/eval-item-tla.jsonnet")
+			require.NoError(t, err)
+			serverOpenTestFile(t, s, filename)
+
+			fileArg, err := json.Marshal(filename)
+			require.NoError(t, err)
+			positionArg, err := json.Marshal(tc.position)
+			require.NoError(t, err)
+			result, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+				Command:   "jsonnet.evalItem",
+				Arguments: []json.RawMessage{fileArg, positionArg},
+			})
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.expected, result.(string))
+		})
+	}
+}
+
+func TestEvalExpression(t *testing.T) {
+	filename, err := filepath.Abs("./testdata/eval-expression.jsonnet")
+	require.NoError(t, err)
+	tlaFilename, err := filepath.Abs("./testdata/eval-expression-tla.jsonnet")
+	require.NoError(t, err)
+
+	// File names with quotes are not spliced into the evaluated code
+	quotedFilename := filepath.Join(t.TempDir(), `it's "quoted".jsonnet`)
+	require.NoError(t, os.WriteFile(quotedFilename, []byte(`{ a: 1 }`), 0o600))
+
+	testCases := []struct {
+		name        string
+		command     string
+		args        []interface{}
+		expected    string
+		expectedErr string
+	}{
+		{name: "whole file", command: "jsonnet.evalExpression", args: []interface{}{quotedFilename, ""}, expected: `{"a": 1}`},
+		{name: "evaluate file", command: "jsonnet.evalFile", args: []interface{}{quotedFilename}, expected: `{"a": 1}`},
+		{name: "dotted field path", command: "jsonnet.evalExpression", args: []interface{}{filename, "nested.field"}, expected: `"nested"`},
+		{name: "field path array", command: "jsonnet.evalExpression", args: []interface{}{filename, []interface{}{"list", 1}}, expected: `2`},
+		{name: "field path array with quoted name", command: "jsonnet.evalExpression", args: []interface{}{filename, []interface{}{"my-field"}}, expected: `"value"`},
+		{name: "expression", command: "jsonnet.evalExpression", args: []interface{}{filename, "main['my-field'] + '!'"}, expected: `"value!"`},
+		{name: "expression using std", command: "jsonnet.evalExpression", args: []interface{}{filename, "std.length(main.list)"}, expected: `3`},
+		{
+			name:     "ext vars",
+			command:  "jsonnet.evalExpression",
+			args:     []interface{}{filename, "main.hidden", map[string]interface{}{"ext_vars": map[string]string{"greeting": "hello"}}},
+			expected: `"hello"`,
+		},
+		{
+			name:    "top-level arguments",
+			command: "jsonnet.evalFile",
+			args: []interface{}{tlaFilename, map[string]interface{}{
+				"tla_vars": map[string]string{"cluster": "dev"},
+				"tla_code": map[string]string{"replicas": "2 + 1"},
+			}},
+			expected: `{"cluster": "dev", "replicas": 3}`,
+		},
+		{
+			name:        "invalid top-level argument name",
+			command:     "jsonnet.evalFile",
+			args:        []interface{}{tlaFilename, map[string]interface{}{"tla_vars": map[string]string{"a) + (b": "x"}}},
+			expectedErr: `invalid top-level argument name "a) + (b"`,
+		},
+		{
+			name:        "runtime error",
+			command:     "jsonnet.evalExpression",
+			args:        []interface{}{filename, "broken"},
+			expectedErr: "this field is broken\n\tat " + filename + ":6:11",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := testServer(t, nil)
+
+			var args []json.RawMessage
+			for _, arg := range tc.args {
+				raw, err := json.Marshal(arg)
+				require.NoError(t, err)
+				args = append(args, raw)
+			}
+			result, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+				Command:   tc.command,
+				Arguments: args,
+			})
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.expected, result.(string))
+		})
+	}
+}
+
+func TestEvalExpressionOutput(t *testing.T) {
+	filename, err := filepath.Abs("./testdata/eval-expression.jsonnet")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		expression  string
+		output      string
+		expected    interface{}
+		expectedErr string
+	}{
+		{name: "json", expression: "main.nested", output: "json", expected: "{\n   \"field\": \"nested\"\n}\n"},
+		{name: "yaml", expression: "main.nested", output: "yaml", expected: "field: nested\n"},
+		{name: "yaml stream", expression: "[main.nested, main.list]", output: "yaml_stream", expected: "---\nfield: nested\n---\n- 1\n- 2\n- 3\n"},
+		{name: "yaml stream of an object", expression: "main.nested", output: "yaml_stream", expectedErr: "stream mode: top-level object was a object, should be an array whose elements hold the JSON for each document in the stream."},
+		{name: "string", expression: "main['my-field']", output: "string", expected: "value\n"},
+		{name: "multi", expression: "{ 'a.json': main.nested, 'b.json': main.list[0] }", output: "multi", expected: map[string]string{
+			"a.json": "{\n   \"field\": \"nested\"\n}\n",
+			"b.json": "1\n",
+		}},
+		{name: "unsupported output", expression: "main", output: "toml", expectedErr: `unsupported output "toml", expected one of: json, yaml, yaml_stream, string, multi`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := testServer(t, nil)
+
+			var args []json.RawMessage
+			for _, arg := range []interface{}{filename, tc.expression, map[string]string{"output": tc.output}} {
+				raw, err := json.Marshal(arg)
+				require.NoError(t, err)
+				args = append(args, raw)
+			}
+			result, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+				Command:   "jsonnet.evalExpression",
+				Arguments: args,
+			})
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+func TestEvalExpressionConfiguredTLAs(t *testing.T) {
+	filename, err := filepath.Abs("./testdata/eval-expression-tla.jsonnet")
+	require.NoError(t, err)
+	s := testServer(t, nil)
+	require.NoError(t, s.DidChangeConfiguration(context.Background(), &protocol.DidChangeConfigurationParams{
+		Settings: map[string]interface{}{
+			"tla_str": map[string]interface{}{"cluster": "dev"},
+			"overrides": []interface{}{
+				map[string]interface{}{"files": "eval-expression-tla.jsonnet", "tla_code": map[string]interface{}{"replicas": "2"}},
+			},
+		},
+	}))
+
+	fileArg, err := json.Marshal(filename)
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		name     string
+		options  string
+		expected string
+	}{
+		{name: "configured", expected: `{"cluster": "dev", "replicas": 2}`},
+		{name: "options override the configuration", options: `{"tla_code": {"cluster": "'prod'"}}`, expected: `{"cluster": "prod", "replicas": 2}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args := []json.RawMessage{fileArg}
+			if tc.options != "" {
+				args = append(args, json.RawMessage(tc.options))
+			}
+			result, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+				Command:   "jsonnet.evalFile",
+				Arguments: args,
+			})
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.expected, result.(string))
+		})
+	}
+}
+
+func TestEvalExpressionConfiguredTLAsOfObjects(t *testing.T) {
+	s := testServer(t, nil)
+	require.NoError(t, s.DidChangeConfiguration(context.Background(), &protocol.DidChangeConfigurationParams{
+		Settings: map[string]interface{}{"tla_str": map[string]interface{}{"cluster": "dev"}},
+	}))
+
+	// Like jsonnet, top-level arguments are ignored for files that are not functions
+	for _, tc := range []struct {
+		command  string
+		file     string
+		args     []string
+		expected string
+	}{
+		{command: "jsonnet.evalFile", file: "./testdata/eval-item.jsonnet", expected: `{"extra": 7, "spec": {"container-name": "app-container", "replicas": 6, "template": {"port": 8080}}}`},
+		{command: "jsonnet.evalExpression", file: "./testdata/eval-expression.jsonnet", args: []string{`"main.nested"`}, expected: `{"field": "nested"}`},
+	} {
+		t.Run(tc.command, func(t *testing.T) {
+			filename, err := filepath.Abs(tc.file)
+			require.NoError(t, err)
+			fileArg, err := json.Marshal(filename)
+			require.NoError(t, err)
+			args := []json.RawMessage{fileArg}
+			for _, arg := range tc.args {
+				args = append(args, json.RawMessage(arg))
+			}
+			result, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+				Command:   tc.command,
+				Arguments: args,
+			})
+			require.NoError(t, err)
+			require.IsType(t, "", result)
+			assert.JSONEq(t, tc.expected, result.(string))
+		})
+	}
+}
+
+func TestEvalExpressionErrorLocations(t *testing.T) {
+	filename, err := filepath.Abs("./testdata/eval-expression.jsonnet")
+	require.NoError(t, err)
+	s := testServer(t, nil)
+
+	fileArg, err := json.Marshal(filename)
+	require.NoError(t, err)
+	_, err = s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+		Command:   "jsonnet.evalExpression",
+		Arguments: []json.RawMessage{fileArg, json.RawMessage(`"main.broken"`)},
+	})
+	require.Error(t, err)
+
+	// The locations are sent to the client in the data of the error
+	response, err := jsonrpc2.NewResponse(jsonrpc2.NewIntID(1), nil, err)
+	require.NoError(t, err)
+	serialized, err := json.Marshal(response)
+	require.NoError(t, err)
+	uri, err := json.Marshal(protocol.URIFromPath(filename))
+	require.NoError(t, err)
+	message, err := json.Marshal("this field is broken\n\tat " + filename + ":6:11")
+	require.NoError(t, err)
+	assert.JSONEq(t, fmt.Sprintf(`{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"error": {
+			"code": -32001,
+			"message": %s,
+			"data": {
+				"message": "this field is broken",
+				"locations": [
+					{"uri": %s, "range": {"start": {"line": 5, "character": 10}, "end": {"line": 5, "character": 38}}}
+				]
+			}
+		}
+	}`, message, uri), string(serialized))
+}
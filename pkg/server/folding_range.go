@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *server) FoldingRange(ctx context.Context, params *protocol.FoldingRangeParams) ([]protocol.FoldingRange, error) {
+	doc, err := s.cache.get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("FoldingRange: %s: %w", errorRetrievingDocument, err)
+	}
+
+	if doc.ast == nil {
+		log.Error("FoldingRange: error parsing the document")
+		return nil, nil
+	}
+
+	return foldingRanges(doc.ast, doc.item.Text), nil
+}
+
+// foldingRanges returns the folding ranges of a document, sorted by start line.
+// Only one range is returned per start line, the first one found (the outermost node) wins.
+func foldingRanges(root ast.Node, text string) []protocol.FoldingRange {
+	byStartLine := map[uint32]protocol.FoldingRange{}
+	add := func(r protocol.FoldingRange) {
+		if r.EndLine <= r.StartLine {
+			return
+		}
+		if _, ok := byStartLine[r.StartLine]; !ok {
+			byStartLine[r.StartLine] = r
+		}
+	}
+
+	for _, comment := range findBlockComments(text) {
+		add(protocol.FoldingRange{
+			StartLine: uint32(comment.Begin.Line - 1),
+			EndLine:   uint32(comment.End.Line - 1),
+			Kind:      string(protocol.Comment),
+		})
+	}
+
+	var imports []ast.LocalBind
+	addImports := func() {
+		if len(imports) > 1 {
+			add(protocol.FoldingRange{
+				StartLine: uint32(imports[0].LocRange.Begin.Line - 1),
+				EndLine:   uint32(imports[len(imports)-1].LocRange.End.Line - 1),
+				Kind:      string(protocol.Imports),
+			})
+		}
+		imports = nil
+	}
+
+	var walk func(node ast.Node, loc ast.LocationRange)
+	walk = func(node ast.Node, loc ast.LocationRange) {
+		switch node := node.(type) {
+		case *ast.Local:
+			for _, bind := range node.Binds {
+				switch bind.Body.(type) {
+				case *ast.Import, *ast.ImportStr:
+					if len(imports) > 0 && bind.LocRange.Begin.Line > imports[len(imports)-1].LocRange.End.Line+1 {
+						addImports()
+					}
+					imports = append(imports, bind)
+				default:
+					addImports()
+				}
+			}
+			// Consecutive `local` statements are nested, the import block continues if the body is another local
+			if _, ok := node.Body.(*ast.Local); !ok {
+				addImports()
+			}
+		case *ast.DesugaredObject, *ast.Array:
+			add(bracketFoldingRange(loc))
+		case *ast.Apply:
+			// Comprehensions are desugared into a call of a std function, which has no location
+			if !node.Target.Loc().IsSet() {
+				add(bracketFoldingRange(loc))
+			}
+		case *ast.Function:
+			if node.Body.Loc().IsSet() && node.Body.Loc().Begin.Line != loc.Begin.Line {
+				add(protocol.FoldingRange{
+					StartLine: uint32(loc.Begin.Line - 1),
+					EndLine:   uint32(node.Body.Loc().End.Line - 1),
+				})
+			}
+		}
+
+		if obj, ok := node.(*ast.DesugaredObject); ok {
+			for _, local := range obj.Locals {
+				walk(local.Body, *local.Body.Loc())
+			}
+			for _, field := range obj.Fields {
+				walk(field.Name, *field.Name.Loc())
+				// Functions defined as object fields (methods) do not have a location, the field has it
+				fieldLoc := *field.Body.Loc()
+				if _, isFunc := field.Body.(*ast.Function); isFunc && !fieldLoc.IsSet() {
+					fieldLoc = field.LocRange
+				}
+				walk(field.Body, fieldLoc)
+			}
+			return
+		}
+		for _, child := range toolutils.Children(node) {
+			walk(child, *child.Loc())
+		}
+	}
+	walk(root, *root.Loc())
+
+	ranges := make([]protocol.FoldingRange, 0, len(byStartLine))
+	for _, r := range byStartLine {
+		ranges = append(ranges, r)
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].StartLine < ranges[j].StartLine
+	})
+	return ranges
+}
+
+// bracketFoldingRange folds everything but the line of the closing bracket,
+// so that it stays visible when the range is folded.
+func bracketFoldingRange(loc ast.LocationRange) protocol.FoldingRange {
+	if !loc.IsSet() {
+		return protocol.FoldingRange{}
+	}
+	return protocol.FoldingRange{
+		StartLine: uint32(loc.Begin.Line - 1),
+		EndLine:   uint32(loc.End.Line - 2),
+	}
+}
+
+// findBlockComments returns the location of all /* */ comments of a Jsonnet document.
+// It skips over strings, text blocks and line comments so that comment markers within them are ignored.
+func findBlockComments(text string) []ast.LocationRange {
+	var comments []ast.LocationRange
+	line, col := 1, 1
+	advance := func(n int) {
+		for _, c := range text[:n] {
+			if c == '\n' {
+				line++
+				col = 1
+			} else {
+				col++
+			}
+		}
+		text = text[n:]
+	}
+	skipUntil := func(end string, offset int) {
+		if i := strings.Index(text[offset:], end); i != -1 {
+			advance(offset + i + len(end))
+		} else {
+			advance(len(text))
+		}
+	}
+
+	for len(text) > 0 {
+		switch {
+		case strings.HasPrefix(text, "/*"):
+			begin := ast.Location{Line: line, Column: col}
+			skipUntil("*/", 2)
+			comments = append(comments, ast.LocationRange{Begin: begin, End: ast.Location{Line: line, Column: col}})
+		case strings.HasPrefix(text, "//"), text[0] == '#':
+			skipUntil("\n", 0)
+		case strings.HasPrefix(text, "|||"):
+			skipUntil("|||", 3)
+		case strings.HasPrefix(text, "@'"), strings.HasPrefix(text, "@\""):
+			// Verbatim strings escape quotes by doubling them, which reads as two consecutive strings
+			skipUntil(text[1:2], 2)
+		case text[0] == '\'' || text[0] == '"':
+			quote := text[0]
+			i := 1
+			for i < len(text) && text[i] != quote {
+				if text[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i < len(text) {
+				i++
+			}
+			advance(i)
+		default:
+			advance(1)
+		}
+	}
+	return comments
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFoldingRange(t *testing.T) {
+	s := testServer(t, nil)
+	uri := serverOpenTestFile(t, s, "./testdata/folding.jsonnet")
+
+	ranges, err := s.FoldingRange(context.Background(), &protocol.FoldingRangeParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []protocol.FoldingRange{
+		{StartLine: 0, EndLine: 2, Kind: "imports"},
+		{StartLine: 4, EndLine: 7, Kind: "comment"},
+		{StartLine: 8, EndLine: 10},  // local function
+		{StartLine: 12, EndLine: 31}, // root object
+		{StartLine: 14, EndLine: 15}, // obj
+		{StartLine: 17, EndLine: 19}, // arr
+		{StartLine: 21, EndLine: 23}, // comp
+		{StartLine: 25, EndLine: 27}, // objComp
+		{StartLine: 29, EndLine: 31}, // method
+	}, ranges)
+}
+
+func TestFindBlockComments(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		expected int
+	}{
+		{name: "single line", text: "/* a */ {}", expected: 1},
+		{name: "in a string", text: "{ a: '/* a */', b: \"/* b */\" }", expected: 0},
+		{name: "in a verbatim string", text: "{ a: @'/* ''a */' }", expected: 0},
+		{name: "in a text block", text: "{ a: |||\n  /* a */\n||| }", expected: 0},
+		{name: "in a line comment", text: "// /* a\n# /* b\n{}", expected: 0},
+		{name: "unterminated", text: "{} /* a", expected: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Len(t, findBlockComments(tc.text), tc.expected)
+		})
+	}
+}
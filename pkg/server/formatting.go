@@ -2,8 +2,12 @@ package server
 
 import (
 	"context"
+	"strings"
 
+	"github.com/google/go-jsonnet/ast"
 	"github.com/google/go-jsonnet/formatter"
+	"github.com/google/go-jsonnet/toolutils"
+	"github.com/grafana/jsonnet-language-server/pkg/position"
 	"github.com/grafana/jsonnet-language-server/pkg/utils"
 	"github.com/hexops/gotextdiff/myers"
 	"github.com/hexops/gotextdiff/span"
@@ -11,14 +15,20 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// onTypeFormattingTriggers are the characters that close a node that can be re-indented on type.
+var onTypeFormattingTriggers = map[string]byte{
+	"}": '{',
+	"]": '[',
+}
+
 func (s *server) Formatting(ctx context.Context, params *protocol.DocumentFormattingParams) ([]protocol.TextEdit, error) {
 	doc, err := s.cache.get(params.TextDocument.URI)
 	if err != nil {
 		return nil, utils.LogErrorf("Formatting: %s: %w", errorRetrievingDocument, err)
 	}
 
-	// TODO(#14): Formatting options should be user configurable.
-	formatted, err := formatter.Format(params.TextDocument.URI.SpanURI().Filename(), doc.item.Text, formatter.DefaultOptions())
+	filename := params.TextDocument.URI.SpanURI().Filename()
+	formatted, err := formatter.Format(filename, doc.item.Text, s.formattingOptions(filename))
 	if err != nil {
 		log.Errorf("error formatting document: %v", err)
 		return nil, nil
@@ -27,6 +37,116 @@ func (s *server) Formatting(ctx context.Context, params *protocol.DocumentFormat
 	return getTextEdits(doc.item.Text, formatted), nil
 }
 
+// WillSaveWaitUntil formats the document before it is saved, if format on save is enabled.
+// Documents that do not parse are saved as is.
+func (s *server) WillSaveWaitUntil(ctx context.Context, params *protocol.WillSaveTextDocumentParams) ([]protocol.TextEdit, error) {
+	if !s.featureFlags().formatOnSave {
+		return nil, nil
+	}
+
+	doc, err := s.cache.get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("WillSaveWaitUntil: %s: %w", errorRetrievingDocument, err)
+	}
+
+	if doc.ast == nil {
+		log.Debugf("WillSaveWaitUntil: skipping formatting of %s, the document does not parse", params.TextDocument.URI)
+		return nil, nil
+	}
+
+	return s.Formatting(ctx, &protocol.DocumentFormattingParams{TextDocument: params.TextDocument})
+}
+
+func (s *server) RangeFormatting(ctx context.Context, params *protocol.DocumentRangeFormattingParams) ([]protocol.TextEdit, error) {
+	doc, err := s.cache.get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("RangeFormatting: %s: %w", errorRetrievingDocument, err)
+	}
+
+	if doc.ast == nil {
+		log.Error("RangeFormatting: error parsing the document")
+		return nil, nil
+	}
+
+	selection := ast.LocationRange{
+		Begin: position.PositionProtocolToAST(params.Range.Start),
+		End:   position.PositionProtocolToAST(params.Range.End),
+	}
+	node := findEnclosingNode(doc.ast, selection)
+	if node == nil {
+		log.Debugf("RangeFormatting: no node encloses %v", params.Range)
+		return nil, nil
+	}
+
+	return s.formatNode(params.TextDocument.URI, doc, node)
+}
+
+func (s *server) OnTypeFormatting(ctx context.Context, params *protocol.DocumentOnTypeFormattingParams) ([]protocol.TextEdit, error) {
+	doc, err := s.cache.get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("OnTypeFormatting: %s: %w", errorRetrievingDocument, err)
+	}
+
+	opening, ok := onTypeFormattingTriggers[params.Ch]
+	if !ok {
+		log.Debugf("OnTypeFormatting: unexpected trigger character %q", params.Ch)
+		return nil, nil
+	}
+
+	// The document is usually incomplete while typing. Only nodes that parse can be formatted
+	if doc.ast == nil {
+		return nil, nil
+	}
+
+	node := findClosedNode(doc.ast, doc.item.Text, position.PositionProtocolToAST(params.Position), opening)
+	if node == nil {
+		log.Debugf("OnTypeFormatting: no node closed by %q at %v", params.Ch, params.Position)
+		return nil, nil
+	}
+
+	return s.formatNode(params.TextDocument.URI, doc, node)
+}
+
+// formatNode formats the source of a single node and indents it to match the line on which the node starts.
+// The returned edits only touch the lines of the node.
+func (s *server) formatNode(uri protocol.DocumentURI, doc *document, node ast.Node) ([]protocol.TextEdit, error) {
+	loc := node.Loc()
+	lines := strings.Split(doc.item.Text, "\n")
+	if loc.End.Line > len(lines) {
+		return nil, utils.LogErrorf("formatNode: node %v is out of the document's bounds", loc)
+	}
+	start := lineOffset(lines, loc.Begin.Line-1) + loc.Begin.Column - 1
+	end := lineOffset(lines, loc.End.Line-1) + loc.End.Column - 1
+
+	filename := uri.SpanURI().Filename()
+	formatted, err := formatter.Format(filename, doc.item.Text[start:end], s.formattingOptions(filename))
+	if err != nil {
+		log.Errorf("error formatting node: %v", err)
+		return nil, nil
+	}
+
+	firstLine := lines[loc.Begin.Line-1]
+	indent := firstLine[:len(firstLine)-len(strings.TrimLeft(firstLine, " \t"))]
+	formattedLines := strings.Split(strings.TrimSuffix(formatted, "\n"), "\n")
+	for i := 1; i < len(formattedLines); i++ {
+		if formattedLines[i] != "" {
+			formattedLines[i] = indent + formattedLines[i]
+		}
+	}
+
+	after := doc.item.Text[:start] + strings.Join(formattedLines, "\n") + doc.item.Text[end:]
+	return getTextEdits(doc.item.Text, after), nil
+}
+
+// lineOffset returns the byte offset at which the given (zero indexed) line starts.
+func lineOffset(lines []string, line int) int {
+	offset := 0
+	for _, l := range lines[:line] {
+		offset += len(l) + 1
+	}
+	return offset
+}
+
 func getTextEdits(before, after string) []protocol.TextEdit {
 	edits := myers.ComputeEdits(span.URI("any"), before, after)
 
@@ -43,3 +163,48 @@ func getTextEdits(before, after string) []protocol.TextEdit {
 
 	return result
 }
+
+// findEnclosingNode returns the smallest node that contains the whole selection.
+func findEnclosingNode(root ast.Node, selection ast.LocationRange) ast.Node {
+	var found ast.Node
+	walkNodes(root, func(node ast.Node) {
+		loc := node.Loc()
+		if !loc.IsSet() || !position.RangeGreaterOrEqual(*loc, selection) {
+			return
+		}
+		if found == nil || position.RangeGreaterOrEqual(*found.Loc(), *loc) {
+			found = node
+		}
+	})
+	return found
+}
+
+// findClosedNode returns the innermost node that starts with the opening character and ends at the given location.
+func findClosedNode(root ast.Node, text string, end ast.Location, opening byte) ast.Node {
+	lines := strings.Split(text, "\n")
+	var found ast.Node
+	walkNodes(root, func(node ast.Node) {
+		loc := node.Loc()
+		if !loc.IsSet() || loc.End != end {
+			return
+		}
+		if loc.Begin.Line > len(lines) || loc.Begin.Column > len(lines[loc.Begin.Line-1]) || lines[loc.Begin.Line-1][loc.Begin.Column-1] != opening {
+			return
+		}
+		if found == nil || position.RangeGreaterOrEqual(*found.Loc(), *loc) {
+			found = node
+		}
+	})
+	return found
+}
+
+// walkNodes calls fn on the given node and all of its descendants.
+func walkNodes(node ast.Node, fn func(ast.Node)) {
+	if node == nil {
+		return
+	}
+	fn(node)
+	for _, child := range toolutils.Children(node) {
+		walkNodes(child, fn)
+	}
+}
@@ -0,0 +1,344 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-jsonnet/formatter"
+	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// formattingConfigFileName is the project-level formatter configuration.
+	// It is a JSON object that accepts the same keys as the `formatting` client setting.
+	formattingConfigFileName = ".jsonnetfmt"
+	editorConfigFileName     = ".editorconfig"
+)
+
+// formattingOptions returns the formatter options to use for the given file.
+// In order of precedence (highest first), they come from:
+// - The closest .jsonnetfmt file
+// - The .editorconfig files applying to the file
+// - The `formatting` setting of the project config file or of the client
+// - The formatter's defaults
+func (s *server) formattingOptions(path string) formatter.Options {
+	opts := applyEditorConfig(s.folderSettingsFor(path).fmtOpts, path)
+
+	configPath, found := findFileUpwards(filepath.Dir(path), formattingConfigFileName)
+	if !found {
+		return opts
+	}
+
+	configOpts, diag := loadFormattingConfig(configPath, opts)
+	if diag == nil {
+		opts = configOpts
+	}
+	s.publishFormattingConfigDiags(configPath, diag)
+	return opts
+}
+
+// publishFormattingConfigDiags publishes the problem of a formatting config file, if any. Files are loaded on
+// every formatting request, their diagnostics are only published when they are first loaded or changed since.
+func (s *server) publishFormattingConfigDiags(path string, diag *protocol.Diagnostic) {
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+	if published, ok := s.formattingConfigs.Load(path); ok && published.(time.Time).Equal(modTime) {
+		return
+	}
+	s.formattingConfigs.Store(path, modTime)
+
+	diags := []protocol.Diagnostic{}
+	if diag != nil {
+		log.Errorf("invalid formatting config %s: %s", path, diag.Message)
+		diags = append(diags, *diag)
+	}
+	// Always publish, so that the diagnostics are cleared once the file is fixed
+	err := s.client.PublishDiagnostics(context.Background(), &protocol.PublishDiagnosticsParams{
+		URI:         protocol.URIFromPath(path),
+		Diagnostics: diags,
+	})
+	if err != nil {
+		log.Errorf("publishFormattingConfigDiags: unable to publish diagnostics: %v\n", err)
+	}
+}
+
+// loadFormattingConfig applies the formatting config file on top of the given options.
+// If the file is malformed, a diagnostic pointing to the issue is returned instead.
+func loadFormattingConfig(path string, opts formatter.Options) (formatter.Options, *protocol.Diagnostic) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return opts, &protocol.Diagnostic{
+			Severity: protocol.SeverityError,
+			Source:   "formatting config",
+			Message:  err.Error(),
+		}
+	}
+
+	var unparsed interface{}
+	if err := json.Unmarshal(content, &unparsed); err != nil {
+		diag := &protocol.Diagnostic{
+			Severity: protocol.SeverityError,
+			Source:   "formatting config",
+			Message:  err.Error(),
+		}
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			// The offset points right after the invalid character
+			line, col := offsetToPosition(content, syntaxErr.Offset-1)
+			diag.Range = position.NewProtocolRange(line, col, line, col+1)
+		}
+		return opts, diag
+	}
+
+	newOpts, err := parseFormattingOpts(unparsed, opts)
+	if err != nil {
+		return opts, &protocol.Diagnostic{
+			Severity: protocol.SeverityError,
+			Source:   "formatting config",
+			Message:  err.Error(),
+		}
+	}
+	return newOpts, nil
+}
+
+// parseFormattingOpts applies the given settings object on top of the given options.
+func parseFormattingOpts(unparsed interface{}, opts formatter.Options) (formatter.Options, error) {
+	newOpts, ok := unparsed.(map[string]interface{})
+	if !ok {
+		return opts, fmt.Errorf("unsupported settings value for formatting. expected json object. got: %T", unparsed)
+	}
+
+	for optName, optValue := range newOpts {
+		var err error
+		switch optName {
+		case "indent":
+			opts.Indent, err = parseFormattingInt(optName, optValue)
+		case "max_blank_lines":
+			opts.MaxBlankLines, err = parseFormattingInt(optName, optValue)
+		case "string_style":
+			opts.StringStyle, err = parseFormattingStyle(optName, optValue, map[string]formatter.StringStyle{
+				"double": formatter.StringStyleDouble,
+				"single": formatter.StringStyleSingle,
+				"leave":  formatter.StringStyleLeave,
+			})
+		case "comment_style":
+			opts.CommentStyle, err = parseFormattingStyle(optName, optValue, map[string]formatter.CommentStyle{
+				"hash":  formatter.CommentStyleHash,
+				"slash": formatter.CommentStyleSlash,
+				"leave": formatter.CommentStyleLeave,
+			})
+		case "pretty_field_names":
+			opts.PrettyFieldNames, err = parseFormattingBool(optName, optValue)
+		case "pad_arrays":
+			opts.PadArrays, err = parseFormattingBool(optName, optValue)
+		case "pad_objects":
+			opts.PadObjects, err = parseFormattingBool(optName, optValue)
+		case "sort_imports":
+			opts.SortImports, err = parseFormattingBool(optName, optValue)
+		case "implicit_plus":
+			opts.UseImplicitPlus, err = parseFormattingBool(optName, optValue)
+		case "strip_everything":
+			opts.StripEverything, err = parseFormattingBool(optName, optValue)
+		case "strip_comments":
+			opts.StripComments, err = parseFormattingBool(optName, optValue)
+		case "strip_all_but_comments":
+			opts.StripAllButComments, err = parseFormattingBool(optName, optValue)
+		default:
+			err = fmt.Errorf("unsupported settings key: \"formatting.%s\"", optName)
+		}
+		if err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+func parseFormattingInt(name string, value interface{}) (int, error) {
+	number, ok := value.(float64)
+	if !ok || number < 0 || number != float64(int(number)) {
+		return 0, fmt.Errorf("unsupported settings value for formatting.%s. expected positive integer. got: %v", name, value)
+	}
+	return int(number), nil
+}
+
+func parseFormattingBool(name string, value interface{}) (bool, error) {
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("unsupported settings value for formatting.%s. expected boolean. got: %T", name, value)
+	}
+	return b, nil
+}
+
+func parseFormattingStyle[T any](name string, value interface{}, styles map[string]T) (T, error) {
+	var zero T
+	str, ok := value.(string)
+	if !ok {
+		return zero, fmt.Errorf("unsupported settings value for formatting.%s. expected string. got: %T", name, value)
+	}
+	style, ok := styles[str]
+	if !ok {
+		return zero, fmt.Errorf("unsupported settings value for formatting.%s: %q", name, str)
+	}
+	return style, nil
+}
+
+// applyEditorConfig applies the indentation settings of the .editorconfig files that match the given file.
+// Files closer to the document take precedence, and the search stops at a file declaring `root = true`.
+func applyEditorConfig(opts formatter.Options, path string) formatter.Options {
+	var configs []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		configPath := filepath.Join(dir, editorConfigFileName)
+		if _, err := os.Stat(configPath); err == nil {
+			configs = append(configs, configPath)
+			if isEditorConfigRoot(configPath) {
+				break
+			}
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	for i := len(configs) - 1; i >= 0; i-- {
+		properties, err := readEditorConfig(configs[i], path)
+		if err != nil {
+			log.Errorf("unable to read %s: %v", configs[i], err)
+			continue
+		}
+
+		indentSize := properties["indent_size"]
+		if indentSize == "tab" {
+			indentSize = properties["tab_width"]
+		}
+		if indentSize == "" {
+			continue
+		}
+		if indent, err := strconv.Atoi(indentSize); err == nil && indent >= 0 {
+			opts.Indent = indent
+		} else {
+			log.Errorf("%s: invalid indent_size %q", configs[i], indentSize)
+		}
+	}
+
+	return opts
+}
+
+func isEditorConfigRoot(configPath string) bool {
+	properties, err := readEditorConfig(configPath, "")
+	return err == nil && strings.EqualFold(properties["root"], "true")
+}
+
+// readEditorConfig returns the properties of an .editorconfig file that apply to the given file.
+// When path is empty, only the preamble (properties before the first section) is returned.
+func readEditorConfig(configPath, path string) (map[string]string, error) {
+	f, err := os.Open(configPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	properties := map[string]string{}
+	inPreamble, matches := true, false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inPreamble = false
+			matches = path != "" && editorConfigGlobMatches(line[1:len(line)-1], filepath.Dir(configPath), path)
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || !(inPreamble || matches) {
+			continue
+		}
+		properties[strings.ToLower(strings.TrimSpace(key))] = strings.ToLower(strings.TrimSpace(value))
+	}
+	return properties, scanner.Err()
+}
+
+// editorConfigGlobMatches reports whether an .editorconfig section glob matches the given file.
+// Globs without a slash match the file's base name. Brace alternatives (`*.{jsonnet,libsonnet}`) are expanded.
+func editorConfigGlobMatches(glob, configDir, path string) bool {
+	target := filepath.Base(path)
+	if strings.Contains(glob, "/") {
+		rel, err := filepath.Rel(configDir, path)
+		if err != nil {
+			return false
+		}
+		target = filepath.ToSlash(rel)
+		glob = strings.TrimPrefix(glob, "/")
+	}
+
+	for _, pattern := range expandBraces(glob) {
+		if matched, _ := filepath.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func expandBraces(glob string) []string {
+	start := strings.Index(glob, "{")
+	if start == -1 {
+		return []string{glob}
+	}
+	end := strings.Index(glob[start:], "}")
+	if end == -1 {
+		return []string{glob}
+	}
+	end += start
+
+	var result []string
+	for _, alternative := range strings.Split(glob[start+1:end], ",") {
+		result = append(result, expandBraces(glob[:start]+alternative+glob[end+1:])...)
+	}
+	return result
+}
+
+// findFileUpwards looks for a file with the given name in dir and its parents.
+func findFileUpwards(dir, name string) (string, bool) {
+	for {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// offsetToPosition converts a byte offset to a zero indexed line and column.
+func offsetToPosition(content []byte, offset int64) (line, col int) {
+	if offset < 0 {
+		offset = 0
+	} else if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	for _, c := range content[:offset] {
+		if c == '\n' {
+			line++
+			col = 0
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
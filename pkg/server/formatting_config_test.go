@@ -0,0 +1,177 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-jsonnet/formatter"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormattingOptions(t *testing.T) {
+	testCases := []struct {
+		name           string
+		files          map[string]string
+		clientSettings map[string]interface{}
+		expectedIndent int
+		expectedStyle  formatter.StringStyle
+	}{
+		{
+			name:           "defaults",
+			expectedIndent: 2,
+			expectedStyle:  formatter.StringStyleSingle,
+		},
+		{
+			name:           "client settings",
+			clientSettings: map[string]interface{}{"indent": 3.0, "string_style": "double"},
+			expectedIndent: 3,
+			expectedStyle:  formatter.StringStyleDouble,
+		},
+		{
+			name: "project config in a parent directory",
+			files: map[string]string{
+				".jsonnetfmt": `{"indent": 4}`,
+			},
+			clientSettings: map[string]interface{}{"string_style": "double"},
+			expectedIndent: 4,
+			expectedStyle:  formatter.StringStyleDouble,
+		},
+		{
+			name: "editorconfig",
+			files: map[string]string{
+				".editorconfig": "root = true\n\n[*]\nindent_size = 8\n\n[*.{jsonnet,libsonnet}]\nindent_size = 4\n",
+			},
+			expectedIndent: 4,
+			expectedStyle:  formatter.StringStyleSingle,
+		},
+		{
+			name: "closest editorconfig wins",
+			files: map[string]string{
+				".editorconfig":     "root = true\n\n[*.jsonnet]\nindent_size = 4\n",
+				"lib/.editorconfig": "[*.jsonnet]\nindent_style = space\nindent_size = tab\ntab_width = 6\n",
+			},
+			expectedIndent: 6,
+			expectedStyle:  formatter.StringStyleSingle,
+		},
+		{
+			name: "project config takes precedence over editorconfig",
+			files: map[string]string{
+				".editorconfig":   "[*.jsonnet]\nindent_size = 4\n",
+				"lib/.jsonnetfmt": `{"indent": 3}`,
+			},
+			expectedIndent: 3,
+			expectedStyle:  formatter.StringStyleSingle,
+		},
+		{
+			name: "malformed project config is ignored",
+			files: map[string]string{
+				".jsonnetfmt": `{"indent": }`,
+			},
+			clientSettings: map[string]interface{}{"indent": 3.0},
+			expectedIndent: 3,
+			expectedStyle:  formatter.StringStyleSingle,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tc.files {
+				path := filepath.Join(dir, name)
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+				require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+			}
+
+			s := testServer(t, nil)
+			if tc.clientSettings != nil {
+				var err error
+				s.fmtOpts, err = parseFormattingOpts(tc.clientSettings, formatter.DefaultOptions())
+				require.NoError(t, err)
+			}
+
+			opts := s.formattingOptions(filepath.Join(dir, "lib", "main.jsonnet"))
+			assert.Equal(t, tc.expectedIndent, opts.Indent)
+			assert.Equal(t, tc.expectedStyle, opts.StringStyle)
+		})
+	}
+}
+
+func TestLoadFormattingConfigErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		content  string
+		expected protocol.Diagnostic
+	}{
+		{
+			name:    "invalid json",
+			content: "{\n  \"indent\": ,\n}",
+			expected: protocol.Diagnostic{
+				Range:    protocol.Range{Start: protocol.Position{Line: 1, Character: 12}, End: protocol.Position{Line: 1, Character: 13}},
+				Severity: protocol.SeverityError,
+				Source:   "formatting config",
+				Message:  "invalid character ',' looking for beginning of value",
+			},
+		},
+		{
+			name:    "unsupported key",
+			content: `{"foo": true}`,
+			expected: protocol.Diagnostic{
+				Severity: protocol.SeverityError,
+				Source:   "formatting config",
+				Message:  `unsupported settings key: "formatting.foo"`,
+			},
+		},
+		{
+			name:    "invalid value",
+			content: `{"string_style": "backticks"}`,
+			expected: protocol.Diagnostic{
+				Severity: protocol.SeverityError,
+				Source:   "formatting config",
+				Message:  `unsupported settings value for formatting.string_style: "backticks"`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), formattingConfigFileName)
+			require.NoError(t, os.WriteFile(path, []byte(tc.content), 0600))
+
+			opts, diag := loadFormattingConfig(path, formatter.DefaultOptions())
+			assert.Equal(t, formatter.DefaultOptions(), opts)
+			require.NotNil(t, diag)
+			assert.Equal(t, tc.expected, *diag)
+		})
+	}
+}
+
+func TestFormattingConfigDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, formattingConfigFileName)
+	configURI := protocol.URIFromPath(configPath)
+	require.NoError(t, os.WriteFile(configPath, []byte(`{"indent": "4"}`), 0600))
+
+	client := &configurationClient{}
+	s := testServer(t, nil)
+	s.client = client
+	filePath := filepath.Join(dir, "main.jsonnet")
+
+	// Diagnostics are published when the file is loaded, not on every formatting request
+	s.formattingOptions(filePath)
+	s.formattingOptions(filePath)
+	assert.Equal(t, 1, client.publicationCount(configURI))
+	require.Len(t, client.publishedDiagnostics(configURI), 1)
+
+	// Changes of the file are published again
+	require.NoError(t, os.WriteFile(configPath, []byte(`{"indent": 4}`), 0600))
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(configPath, modTime, modTime))
+	assert.Equal(t, 4, s.formattingOptions(filePath).Indent)
+	s.formattingOptions(filePath)
+	assert.Equal(t, 2, client.publicationCount(configURI))
+	assert.Empty(t, client.publishedDiagnostics(configURI))
+}
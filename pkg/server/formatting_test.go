@@ -1,10 +1,12 @@
 package server
 
 import (
+	"context"
 	"testing"
 
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetTextEdits(t *testing.T) {
@@ -71,3 +73,214 @@ func TestGetTextEdits(t *testing.T) {
 		})
 	}
 }
+
+func TestRangeFormatting(t *testing.T) {
+	const fileContent = `{
+a:{
+b:   1,
+},
+c:   [1,
+ 2],
+}
+`
+	testCases := []struct {
+		name      string
+		selection protocol.Range
+		expected  []protocol.TextEdit
+	}{
+		{
+			name: "selection within an object only formats that object",
+			selection: protocol.Range{
+				Start: protocol.Position{Line: 2, Character: 0},
+				End:   protocol.Position{Line: 2, Character: 2},
+			},
+			expected: []protocol.TextEdit{
+				{
+					Range: protocol.Range{
+						Start: protocol.Position{Line: 2, Character: 0},
+						End:   protocol.Position{Line: 3, Character: 0},
+					},
+					NewText: "",
+				},
+				{
+					Range: protocol.Range{
+						Start: protocol.Position{Line: 3, Character: 0},
+						End:   protocol.Position{Line: 3, Character: 0},
+					},
+					NewText: "  b: 1,\n",
+				},
+			},
+		},
+		{
+			name: "selection spanning array elements formats the array",
+			selection: protocol.Range{
+				Start: protocol.Position{Line: 4, Character: 5},
+				End:   protocol.Position{Line: 5, Character: 2},
+			},
+			expected: []protocol.TextEdit{
+				{
+					Range: protocol.Range{
+						Start: protocol.Position{Line: 4, Character: 0},
+						End:   protocol.Position{Line: 5, Character: 0},
+					},
+					NewText: "",
+				},
+				{
+					Range: protocol.Range{
+						Start: protocol.Position{Line: 5, Character: 0},
+						End:   protocol.Position{Line: 6, Character: 0},
+					},
+					NewText: "",
+				},
+				{
+					Range: protocol.Range{
+						Start: protocol.Position{Line: 6, Character: 0},
+						End:   protocol.Position{Line: 6, Character: 0},
+					},
+					NewText: "c:   [\n",
+				},
+				{
+					Range: protocol.Range{
+						Start: protocol.Position{Line: 6, Character: 0},
+						End:   protocol.Position{Line: 6, Character: 0},
+					},
+					NewText: "  1,\n",
+				},
+				{
+					Range: protocol.Range{
+						Start: protocol.Position{Line: 6, Character: 0},
+						End:   protocol.Position{Line: 6, Character: 0},
+					},
+					NewText: "  2,\n",
+				},
+				{
+					Range: protocol.Range{
+						Start: protocol.Position{Line: 6, Character: 0},
+						End:   protocol.Position{Line: 6, Character: 0},
+					},
+					NewText: "],\n",
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, fileURI := testServerWithFile(t, nil, fileContent)
+			got, err := s.RangeFormatting(context.Background(), &protocol.DocumentRangeFormattingParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: fileURI},
+				Range:        tc.selection,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestOnTypeFormatting(t *testing.T) {
+	const fileContent = `{
+  a: [
+ 1,
+      2],
+  b:   'c',
+}
+`
+	s, fileURI := testServerWithFile(t, nil, fileContent)
+	got, err := s.OnTypeFormatting(context.Background(), &protocol.DocumentOnTypeFormattingParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: fileURI},
+		Position:     protocol.Position{Line: 3, Character: 8},
+		Ch:           "]",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []protocol.TextEdit{
+		{
+			Range: protocol.Range{
+				Start: protocol.Position{Line: 2, Character: 0},
+				End:   protocol.Position{Line: 3, Character: 0},
+			},
+			NewText: "",
+		},
+		{
+			Range: protocol.Range{
+				Start: protocol.Position{Line: 3, Character: 0},
+				End:   protocol.Position{Line: 4, Character: 0},
+			},
+			NewText: "",
+		},
+		{
+			Range: protocol.Range{
+				Start: protocol.Position{Line: 4, Character: 0},
+				End:   protocol.Position{Line: 4, Character: 0},
+			},
+			NewText: "    1,\n",
+		},
+		{
+			Range: protocol.Range{
+				Start: protocol.Position{Line: 4, Character: 0},
+				End:   protocol.Position{Line: 4, Character: 0},
+			},
+			NewText: "    2,\n",
+		},
+		{
+			Range: protocol.Range{
+				Start: protocol.Position{Line: 4, Character: 0},
+				End:   protocol.Position{Line: 4, Character: 0},
+			},
+			NewText: "  ],\n",
+		},
+	}, got)
+}
+
+func TestWillSaveWaitUntil(t *testing.T) {
+	testCases := []struct {
+		name         string
+		formatOnSave bool
+		fileContent  string
+		expected     []protocol.TextEdit
+	}{
+		{
+			name:         "disabled",
+			formatOnSave: false,
+			fileContent:  "{a:   1}\n",
+		},
+		{
+			name:         "enabled",
+			formatOnSave: true,
+			fileContent:  "{a:   1}\n",
+			expected: []protocol.TextEdit{
+				{
+					Range: protocol.Range{
+						Start: protocol.Position{Line: 0, Character: 0},
+						End:   protocol.Position{Line: 1, Character: 0},
+					},
+					NewText: "",
+				},
+				{
+					Range: protocol.Range{
+						Start: protocol.Position{Line: 1, Character: 0},
+						End:   protocol.Position{Line: 1, Character: 0},
+					},
+					NewText: "{ a: 1 }\n",
+				},
+			},
+		},
+		{
+			name:         "document does not parse",
+			formatOnSave: true,
+			fileContent:  "{a:   1\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, fileURI := testServerWithFile(t, nil, tc.fileContent)
+			s.FormatOnSave = tc.formatOnSave
+			got, err := s.WillSaveWaitUntil(context.Background(), &protocol.WillSaveTextDocumentParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: fileURI},
+				Reason:       protocol.Manual,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
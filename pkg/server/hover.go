@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"fmt"
+	"path/filepath"
 	"strings"
 
 	"github.com/google/go-jsonnet/ast"
@@ -25,6 +26,14 @@ func (s *server) Hover(ctx context.Context, params *protocol.HoverParams) (*prot
 		return nil, nil
 	}
 
+	if filepath.Base(doc.item.URI.SpanURI().Filename()) == jsonnetfileName {
+		return jsonnetfileHover(doc, params.Position), nil
+	}
+
+	if hover := s.nativeFunctionHover(doc.ast, doc.item.URI.SpanURI().Filename(), params.Position); hover != nil {
+		return hover, nil
+	}
+
 	stack, err := processing.FindNodeByPosition(doc.ast, position.PositionProtocolToAST(params.Position))
 	if err != nil {
 		return nil, err
@@ -84,5 +93,5 @@ func (s *server) Hover(ctx context.Context, params *protocol.HoverParams) (*prot
 		}
 	}
 
-	return nil, nil
+	return tankaEnvironmentHover(doc.ast, node, doc.item.URI.SpanURI().Filename()), nil
 }
@@ -0,0 +1,348 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/grafana/jsonnet-language-server/pkg/processing"
+	"github.com/grafana/jsonnet-language-server/pkg/stdlib"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/jsonrpc2"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	inlayHintMethod = "textDocument/inlayHint"
+	// inlayHintEvalTimeout is the maximum time spent evaluating the value of a single local
+	inlayHintEvalTimeout = 100 * time.Millisecond
+	// inlayHintMaxValueLength is the maximum length of an evaluated value shown as a hint
+	inlayHintMaxValueLength = 30
+)
+
+// The protocol library predates inlay hints (LSP 3.17), these types mirror the specification.
+
+// InlayHintKind is the kind of an inlay hint.
+type InlayHintKind uint32
+
+const (
+	InlayHintKindType      InlayHintKind = 1
+	InlayHintKindParameter InlayHintKind = 2
+)
+
+// InlayHintCapabilitiesHandler records whether the client supports the dynamic registration of inlay hints,
+// from the `initialize` request. The protocol library predates inlay hints and drops their client capabilities.
+func (s *server) InlayHintCapabilitiesHandler(handler jsonrpc2.Handler) jsonrpc2.Handler {
+	return func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		if req.Method() == "initialize" {
+			var params struct {
+				Capabilities struct {
+					TextDocument struct {
+						InlayHint struct {
+							DynamicRegistration bool `json:"dynamicRegistration"`
+						} `json:"inlayHint"`
+					} `json:"textDocument"`
+				} `json:"capabilities"`
+			}
+			if err := json.Unmarshal(req.Params(), &params); err == nil {
+				s.registerInlayHints = params.Capabilities.TextDocument.InlayHint.DynamicRegistration
+			}
+		}
+		return handler(ctx, reply, req)
+	}
+}
+
+// InlayHintParams are the parameters of a `textDocument/inlayHint` request.
+type InlayHintParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	Range        protocol.Range                  `json:"range"`
+}
+
+// InlayHint is a label shown inline in the editor.
+type InlayHint struct {
+	Position     protocol.Position `json:"position"`
+	Label        string            `json:"label"`
+	Kind         InlayHintKind     `json:"kind,omitempty"`
+	PaddingLeft  bool              `json:"paddingLeft,omitempty"`
+	PaddingRight bool              `json:"paddingRight,omitempty"`
+}
+
+func (s *server) InlayHint(ctx context.Context, params *InlayHintParams) ([]InlayHint, error) {
+	doc, err := s.cache.get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("InlayHint: %s: %w", errorRetrievingDocument, err)
+	}
+
+	if doc.ast == nil {
+		// Inlay hints are requested on every change. Throwing an error on each request is noisy
+		log.Error("InlayHint: error parsing the document")
+		return nil, nil
+	}
+
+	vm, err := s.getVM(doc.item.URI.SpanURI().Filename())
+	if err != nil {
+		return nil, utils.LogErrorf("InlayHint: error creating the VM: %w", err)
+	}
+
+	hints := findParameterHints(doc.ast, params.Range, s.stdlib, vm)
+	if s.featureFlags().inlayHintValues {
+		hints = append(hints, findValueHints(doc.ast, doc.item.Text, params.Range, vm, inlayHintEvalTimeout)...)
+	}
+	return hints, nil
+}
+
+// findParameterHints returns the names of the parameters at the positional arguments of function calls.
+// Arguments that are variables with the same name as their parameter are skipped.
+func findParameterHints(root ast.Node, rng protocol.Range, functions []stdlib.Function, vm *jsonnet.VM) []InlayHint {
+	var hints []InlayHint
+	walkNodes(root, func(node ast.Node) {
+		apply, ok := node.(*ast.Apply)
+		if !ok || !apply.Target.Loc().IsSet() || len(apply.Arguments.Positional) == 0 {
+			return
+		}
+		params := findCalledFunctionParams(root, apply.Target, functions, vm)
+		for i, arg := range apply.Arguments.Positional {
+			if i >= len(params) {
+				break
+			}
+			begin := position.PositionASTToProtocol(arg.Expr.Loc().Begin)
+			if !arg.Expr.Loc().IsSet() || !inProtocolRange(begin, rng) {
+				continue
+			}
+			if v, ok := arg.Expr.(*ast.Var); ok && string(v.Id) == params[i] {
+				continue
+			}
+			hints = append(hints, InlayHint{
+				Position:     begin,
+				Label:        params[i] + ":",
+				Kind:         InlayHintKindParameter,
+				PaddingRight: true,
+			})
+		}
+	})
+	return hints
+}
+
+// findCalledFunctionParams returns the parameter names of the function called by an apply target.
+// Standard library functions, locals and fields defined in the document are supported.
+func findCalledFunctionParams(root ast.Node, target ast.Node, functions []stdlib.Function, vm *jsonnet.VM) []string {
+	if index, ok := target.(*ast.Index); ok {
+		if v, ok := index.Target.(*ast.Var); ok && v.Id == "std" {
+			if name, ok := index.Index.(*ast.LiteralString); ok {
+				for _, function := range functions {
+					if function.Name == name.Value {
+						return function.Params
+					}
+				}
+			}
+			return nil
+		}
+	}
+
+	// Variables are found from their start, indexes from the last character of their name
+	loc := target.Loc().Begin
+	if _, ok := target.(*ast.Var); !ok {
+		loc = ast.Location{Line: target.Loc().End.Line, Column: target.Loc().End.Column - 1}
+	}
+	stack, err := processing.FindNodeByPosition(root, loc)
+	if err != nil || stack.IsEmpty() || stack.Peek() != target {
+		return nil
+	}
+
+	var body ast.Node
+	if v, ok := target.(*ast.Var); ok {
+		bind := processing.FindBindByIdViaStack(stack, v.Id)
+		if bind == nil {
+			return nil
+		}
+		if bind.Fun != nil {
+			return functionParamNames(bind.Fun)
+		}
+		body = bind.Body
+	} else {
+		definition := resolveSymbol(stack, target, vm)
+		if definition == nil || !definition.isField {
+			return nil
+		}
+		walkNodes(root, func(node ast.Node) {
+			if object, ok := node.(*ast.DesugaredObject); ok {
+				for _, field := range object.Fields {
+					if field.LocRange.Begin == definition.location {
+						body = field.Body
+					}
+				}
+			}
+		})
+	}
+
+	if function, ok := body.(*ast.Function); ok {
+		return functionParamNames(function)
+	}
+	return nil
+}
+
+func functionParamNames(function *ast.Function) []string {
+	names := make([]string, 0, len(function.Parameters))
+	for _, param := range function.Parameters {
+		names = append(names, string(param.Name))
+	}
+	return names
+}
+
+// findValueHints returns the evaluated value of locals that are computed from constants, e.g. `local b = 2 * a`.
+// Locals that are literals or that evaluate to long values are skipped. Once an evaluation times out, the VM is
+// still used by it, so no other value is evaluated.
+func findValueHints(root ast.Node, text string, rng protocol.Range, vm *jsonnet.VM, timeout time.Duration) []InlayHint {
+	lines := strings.Split(text, "\n")
+	var hints []InlayHint
+	timedOut := false
+	walkNodes(root, func(node ast.Node) {
+		if timedOut {
+			return
+		}
+		var binds ast.LocalBinds
+		switch node := node.(type) {
+		case *ast.Local:
+			binds = node.Binds
+		case *ast.DesugaredObject:
+			binds = node.Locals
+		default:
+			return
+		}
+
+		for _, bind := range binds {
+			loc := bind.Body.Loc()
+			end := position.PositionASTToProtocol(loc.End)
+			if bind.Fun != nil || !loc.IsSet() || isLiteral(bind.Body) || !inProtocolRange(end, rng) {
+				continue
+			}
+			deps := map[ast.Identifier]ast.Node{}
+			if !findConstantDependencies(root, bind.Body, deps) {
+				continue
+			}
+
+			// Dependencies are bound in a single local since they can reference each other in any order
+			names := make([]string, 0, len(deps))
+			for name := range deps {
+				names = append(names, string(name))
+			}
+			sort.Strings(names)
+			snippet := nodeSource(lines, *loc)
+			if len(names) > 0 {
+				depBinds := make([]string, 0, len(names))
+				for _, name := range names {
+					depBinds = append(depBinds, fmt.Sprintf("%s = %s", name, nodeSource(lines, *deps[ast.Identifier(name)].Loc())))
+				}
+				snippet = fmt.Sprintf("local %s;\n%s", strings.Join(depBinds, ",\n"), snippet)
+			}
+
+			value, err := evaluateWithTimeout(vm, snippet, timeout)
+			if errors.Is(err, errEvaluationTimedOut) {
+				log.Debugf("InlayHint: %v, skipping the other values", err)
+				timedOut = true
+				return
+			}
+			if err != nil {
+				log.Debugf("InlayHint: unable to evaluate local %s: %v", bind.Variable, err)
+				continue
+			}
+			value = strings.TrimSpace(value)
+			if strings.Contains(value, "\n") || len(value) > inlayHintMaxValueLength {
+				continue
+			}
+			hints = append(hints, InlayHint{
+				Position:    end,
+				Label:       "= " + value,
+				PaddingLeft: true,
+			})
+		}
+	})
+	return hints
+}
+
+// findConstantDependencies checks that the node is only made of literals, operators and constant locals.
+// The locals that the node depends on are added to deps. Locals shadowing each other or cycles are not constant.
+func findConstantDependencies(root, node ast.Node, deps map[ast.Identifier]ast.Node) bool {
+	switch node := node.(type) {
+	case *ast.LiteralNull, *ast.LiteralBoolean, *ast.LiteralNumber, *ast.LiteralString:
+		return true
+	case *ast.Unary:
+		return findConstantDependencies(root, node.Expr, deps)
+	case *ast.Binary:
+		return findConstantDependencies(root, node.Left, deps) && findConstantDependencies(root, node.Right, deps)
+	case *ast.Var:
+		if !node.Loc().IsSet() {
+			return false
+		}
+		stack, err := processing.FindNodeByPosition(root, node.Loc().Begin)
+		if err != nil || stack.IsEmpty() || stack.Peek() != node {
+			return false
+		}
+		if processing.FindParameterByIdViaStack(stack, node.Id) != nil {
+			return false
+		}
+		bind := processing.FindBindByIdViaStack(stack, node.Id)
+		if bind == nil || bind.Fun != nil || !bind.Body.Loc().IsSet() {
+			return false
+		}
+		if existing, ok := deps[node.Id]; ok {
+			return existing == bind.Body
+		}
+		deps[node.Id] = bind.Body
+		return findConstantDependencies(root, bind.Body, deps)
+	}
+	return false
+}
+
+func isLiteral(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.LiteralNull, *ast.LiteralBoolean, *ast.LiteralNumber, *ast.LiteralString:
+		return true
+	}
+	return false
+}
+
+// errEvaluationTimedOut is returned by evaluateWithTimeout when the evaluation takes too long.
+var errEvaluationTimedOut = errors.New("evaluation timed out")
+
+// evaluateWithTimeout evaluates a snippet, giving up after the timeout.
+// The VM cannot be interrupted so a timed out evaluation keeps running in the background, the VM must not be
+// used afterwards.
+func evaluateWithTimeout(vm *jsonnet.VM, snippet string, timeout time.Duration) (string, error) {
+	type result struct {
+		value string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := vm.EvaluateAnonymousSnippet("inlay-hint", snippet)
+		done <- result{value, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-time.After(timeout):
+		return "", fmt.Errorf("%w after %s", errEvaluationTimedOut, timeout)
+	}
+}
+
+// nodeSource returns the source code in the given range.
+func nodeSource(lines []string, loc ast.LocationRange) string {
+	text := strings.Join(lines, "\n")
+	start := lineOffset(lines, loc.Begin.Line-1) + loc.Begin.Column - 1
+	end := lineOffset(lines, loc.End.Line-1) + loc.End.Column - 1
+	return text[start:end]
+}
+
+func inProtocolRange(pos protocol.Position, rng protocol.Range) bool {
+	return !positionBefore(pos, rng.Start) && !positionBefore(rng.End, pos)
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-jsonnet"
+
+	"github.com/grafana/jsonnet-language-server/pkg/stdlib"
+	"github.com/jdbaldry/go-language-server-protocol/jsonrpc2"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInlayHint(t *testing.T) {
+	functions := []stdlib.Function{{Name: "map", Params: []string{"func", "arr"}}}
+	for _, values := range []bool{false, true} {
+		t.Run(fmt.Sprintf("values=%t", values), func(t *testing.T) {
+			s := testServer(t, functions)
+			s.InlayHintValues = values
+			uri := serverOpenTestFile(t, s, "./testdata/inlay-hints.jsonnet")
+
+			// Requests are decoded from generic JSON, as they are received by NonstandardRequest
+			hints, err := s.NonstandardRequest(context.Background(), inlayHintMethod, map[string]interface{}{
+				"textDocument": map[string]interface{}{"uri": string(uri)},
+				"range": map[string]interface{}{
+					"start": map[string]interface{}{"line": 0, "character": 0},
+					"end":   map[string]interface{}{"line": 20, "character": 0},
+				},
+			})
+			require.NoError(t, err)
+
+			expected := []InlayHint{
+				{Position: protocol.Position{Line: 7, Character: 11}, Label: "a:", Kind: InlayHintKindParameter, PaddingRight: true},
+				{Position: protocol.Position{Line: 7, Character: 21}, Label: "b:", Kind: InlayHintKindParameter, PaddingRight: true},
+				{Position: protocol.Position{Line: 9, Character: 18}, Label: "func:", Kind: InlayHintKindParameter, PaddingRight: true},
+				{Position: protocol.Position{Line: 9, Character: 37}, Label: "arr:", Kind: InlayHintKindParameter, PaddingRight: true},
+				{Position: protocol.Position{Line: 10, Character: 20}, Label: "factor:", Kind: InlayHintKindParameter, PaddingRight: true},
+				{Position: protocol.Position{Line: 10, Character: 26}, Label: "offset:", Kind: InlayHintKindParameter, PaddingRight: true},
+			}
+			if values {
+				expected = append(expected,
+					InlayHint{Position: protocol.Position{Line: 1, Character: 25}, Label: "= 9", PaddingLeft: true},
+					InlayHint{Position: protocol.Position{Line: 2, Character: 30}, Label: `= "app-9"`, PaddingLeft: true},
+				)
+			}
+			assert.ElementsMatch(t, expected, hints)
+		})
+	}
+}
+
+func TestInlayHintRange(t *testing.T) {
+	s := testServer(t, nil)
+	uri := serverOpenTestFile(t, s, "./testdata/inlay-hints.jsonnet")
+
+	hints, err := s.InlayHint(context.Background(), &InlayHintParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+		Range:        protocol.Range{Start: protocol.Position{Line: 10}, End: protocol.Position{Line: 11}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []InlayHint{
+		{Position: protocol.Position{Line: 10, Character: 20}, Label: "factor:", Kind: InlayHintKindParameter, PaddingRight: true},
+		{Position: protocol.Position{Line: 10, Character: 26}, Label: "offset:", Kind: InlayHintKindParameter, PaddingRight: true},
+	}, hints)
+}
+
+func TestInlayHintValueTimeout(t *testing.T) {
+	// The first local takes long to evaluate, the second one would be evaluated on the same VM
+	text := fmt.Sprintf("local slow = %s;\nlocal fast = 2 + 2;\n{ slow: slow, fast: fast }\n", strings.Repeat("1 + ", 20000)+"1")
+	root, err := jsonnet.SnippetToAST("inlay-hint-timeout.jsonnet", text)
+	require.NoError(t, err)
+
+	rng := protocol.Range{End: protocol.Position{Line: 3}}
+	hints := findValueHints(root, text, rng, jsonnet.MakeVM(), time.Nanosecond)
+	assert.Empty(t, hints, "no value is evaluated after a timeout")
+
+	hints = findValueHints(root, text, rng, jsonnet.MakeVM(), time.Minute)
+	assert.Equal(t, []InlayHint{
+		{Position: protocol.Position{Line: 0, Character: 80014}, Label: "= 20001", PaddingLeft: true},
+		{Position: protocol.Position{Line: 1, Character: 18}, Label: "= 4", PaddingLeft: true},
+	}, hints)
+}
+
+func TestInlayHintRegistration(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		capabilities map[string]interface{}
+		expected     []string
+	}{
+		{name: "no inlay hint capabilities", capabilities: map[string]interface{}{}},
+		{
+			name:         "static registration",
+			capabilities: map[string]interface{}{"textDocument": map[string]interface{}{"inlayHint": map[string]interface{}{}}},
+		},
+		{
+			name:         "dynamic registration",
+			capabilities: map[string]interface{}{"textDocument": map[string]interface{}{"inlayHint": map[string]interface{}{"dynamicRegistration": true}}},
+			expected:     []string{inlayHintMethod},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &configurationClient{}
+			s := NewServer("jsonnet-language-server", "dev", client).WithStaticVM([]string{})
+			handler := s.InlayHintCapabilitiesHandler(func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+				var params protocol.ParamInitialize
+				require.NoError(t, json.Unmarshal(req.Params(), &params))
+				_, err := s.Initialize(ctx, &params)
+				return err
+			})
+			call, err := jsonrpc2.NewCall(jsonrpc2.NewIntID(1), "initialize", map[string]interface{}{"capabilities": tc.capabilities})
+			require.NoError(t, err)
+			require.NoError(t, handler(context.Background(), nil, call))
+			require.NoError(t, s.Initialized(context.Background(), &protocol.InitializedParams{}))
+
+			var methods []string
+			for _, registration := range client.registrations {
+				if registration.Method == inlayHintMethod {
+					methods = append(methods, registration.Method)
+				}
+			}
+			assert.Equal(t, tc.expected, methods)
+		})
+	}
+}
+
+func TestNonstandardRequestUnknownMethod(t *testing.T) {
+	s := testServer(t, nil)
+	_, err := s.NonstandardRequest(context.Background(), "jsonnet/unknown", nil)
+	assert.ErrorIs(t, err, jsonrpc2.ErrMethodNotFound)
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	jsonnetfileName     = "jsonnetfile.json"
+	jsonnetfileLockName = "jsonnetfile.lock.json"
+)
+
+// jsonnetfile is the content of a jsonnet-bundler jsonnetfile.json or jsonnetfile.lock.json.
+type jsonnetfile struct {
+	Dependencies []bundlerDependency `json:"dependencies"`
+}
+
+// bundlerDependency is a dependency of a jsonnet-bundler project.
+type bundlerDependency struct {
+	Source struct {
+		Git *struct {
+			Remote string `json:"remote"`
+			Subdir string `json:"subdir"`
+		} `json:"git"`
+		Local *struct {
+			Directory string `json:"directory"`
+		} `json:"local"`
+	} `json:"source"`
+	Version string `json:"version"`
+	Sum     string `json:"sum"`
+}
+
+// packagePath returns the path of the dependency in the vendor directory, such as
+// github.com/grafana/jsonnet-libs/ksonnet-util.
+func (d bundlerDependency) packagePath() string {
+	switch {
+	case d.Source.Git != nil:
+		remote := d.Source.Git.Remote
+		if i := strings.Index(remote, "://"); i >= 0 {
+			remote = remote[i+3:]
+		} else if strings.HasPrefix(remote, "git@") {
+			// SSH remotes, such as git@github.com:grafana/jsonnet-libs.git
+			remote = strings.Replace(strings.TrimPrefix(remote, "git@"), ":", "/", 1)
+		}
+		remote = strings.TrimSuffix(strings.TrimSuffix(remote, "/"), ".git")
+		return path.Join(remote, d.Source.Git.Subdir)
+	case d.Source.Local != nil:
+		return path.Base(filepath.ToSlash(d.Source.Local.Directory))
+	}
+	return ""
+}
+
+// bundlerProject is a jsonnet-bundler project: the directory of a jsonnetfile.json.
+type bundlerProject struct {
+	dir string
+	// lock is nil if the project has no jsonnetfile.lock.json
+	lock *jsonnetfile
+}
+
+// findBundlerProject returns the jsonnet-bundler project of the closest jsonnetfile.json above a file, if any.
+func findBundlerProject(filename string) *bundlerProject {
+	jsonnetfilePath, found := findFileUpwards(filepath.Dir(filename), jsonnetfileName)
+	if !found {
+		return nil
+	}
+
+	project := &bundlerProject{dir: filepath.Dir(jsonnetfilePath)}
+	lock, err := readJsonnetfile(filepath.Join(project.dir, jsonnetfileLockName))
+	if err != nil && !os.IsNotExist(err) {
+		log.Debugf("Unable to read the jsonnet-bundler lock file of %s: %v", project.dir, err)
+	}
+	project.lock = lock
+	return project
+}
+
+func readJsonnetfile(path string) (*jsonnetfile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file jsonnetfile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// jpaths returns the import paths that jsonnet-bundler projects use: the libraries of the project take
+// precedence over the vendor directory, which holds the packages and the links of their legacy names.
+func (p *bundlerProject) jpaths() []string {
+	var jpaths []string
+	for _, dir := range []string{"vendor", "lib"} {
+		if info, err := os.Stat(filepath.Join(p.dir, dir)); err == nil && info.IsDir() {
+			jpaths = append(jpaths, filepath.Join(p.dir, dir))
+		}
+	}
+	return jpaths
+}
+
+// lockedDependency returns the locked dependency that provides an import, nil if there is none.
+func (p *bundlerProject) lockedDependency(importPath string) *bundlerDependency {
+	if p == nil || p.lock == nil {
+		return nil
+	}
+	for i, dep := range p.lock.Dependencies {
+		packagePath := dep.packagePath()
+		if packagePath != "" && (importPath == packagePath || strings.HasPrefix(importPath, packagePath+"/")) {
+			return &p.lock.Dependencies[i]
+		}
+	}
+	return nil
+}
+
+// getBundlerImportDiags reports the imports of packages, such as github.com/grafana/jsonnet-libs/ksonnet-util/kausal.libsonnet,
+// that are not in the jsonnetfile.lock.json of the project.
+func (s *server) getBundlerImportDiags(doc *document) (diags []protocol.Diagnostic) {
+	if doc.ast == nil {
+		return nil
+	}
+	filename := doc.item.URI.SpanURI().Filename()
+	project := findBundlerProject(filename)
+	if project == nil {
+		return nil
+	}
+
+	searchPaths := s.importSearchPaths(filename)
+	for _, importPath := range findImportPaths(doc.ast) {
+		if !project.isPackageImport(importPath.Value, searchPaths) || project.lockedDependency(importPath.Value) != nil {
+			continue
+		}
+		diags = append(diags, protocol.Diagnostic{
+			Range:    position.RangeASTToProtocol(*importPath.Loc()),
+			Severity: protocol.SeverityWarning,
+			Source:   "jsonnet-bundler",
+			Message:  fmt.Sprintf("the package of import %q is missing from %s. Add it with `jb install`", importPath.Value, filepath.Join(project.dir, jsonnetfileLockName)),
+		})
+	}
+	return diags
+}
+
+// isPackageImport returns whether an import is of a package: it starts with a host, such as github.com, which is
+// a directory of the vendor directory, or it does not point to a file of the search paths of the importing file.
+// Directories named like hosts, such as config.d, are common in projects and in their import paths.
+func (p *bundlerProject) isPackageImport(importPath string, searchPaths []string) bool {
+	host, rest, found := strings.Cut(importPath, "/")
+	if !found || rest == "" || !strings.Contains(host, ".") || strings.HasPrefix(host, ".") {
+		return false
+	}
+	if info, err := os.Stat(filepath.Join(p.dir, "vendor", host)); err == nil && info.IsDir() {
+		return true
+	}
+	for _, dir := range searchPaths {
+		if _, err := os.Stat(filepath.Join(dir, importPath)); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// jsonnetfileHover shows the locked version of the dependency of a jsonnetfile.json at a position.
+func jsonnetfileHover(doc *document, pos protocol.Position) *protocol.Hover {
+	dependencies := jsonnetfileDependencies(doc.ast)
+	if dependencies == nil {
+		return nil
+	}
+	index := -1
+	for i, element := range dependencies.Elements {
+		if position.InRange(position.PositionProtocolToAST(pos), *element.Expr.Loc()) {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil
+	}
+
+	var file jsonnetfile
+	if err := json.Unmarshal([]byte(doc.item.Text), &file); err != nil || index >= len(file.Dependencies) {
+		return nil
+	}
+	packagePath := file.Dependencies[index].packagePath()
+	if packagePath == "" {
+		return nil
+	}
+
+	value := fmt.Sprintf("**%s**\n\nNot locked, run `jb install`", packagePath)
+	if locked := findBundlerProject(doc.item.URI.SpanURI().Filename()).lockedDependency(packagePath); locked != nil {
+		value = fmt.Sprintf("**%s**\n\n- Locked version: `%s`", packagePath, locked.Version)
+		if locked.Sum != "" {
+			value += fmt.Sprintf("\n- Sum: `%s`", locked.Sum)
+		}
+	}
+	return &protocol.Hover{
+		Range:    position.RangeASTToProtocol(*dependencies.Elements[index].Expr.Loc()),
+		Contents: protocol.MarkupContent{Kind: protocol.Markdown, Value: value},
+	}
+}
+
+// jsonnetfileDependencies returns the array of the `dependencies` field of a jsonnetfile.json.
+func jsonnetfileDependencies(root ast.Node) *ast.Array {
+	object, ok := topLevelExpression(root).(*ast.DesugaredObject)
+	if !ok {
+		return nil
+	}
+	for _, field := range object.Fields {
+		if name, ok := field.Name.(*ast.LiteralString); ok && name.Value == "dependencies" {
+			array, _ := topLevelExpression(field.Body).(*ast.Array)
+			return array
+		}
+	}
+	return nil
+}
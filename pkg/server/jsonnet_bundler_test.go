@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundlerJPaths(t *testing.T) {
+	filename, err := filepath.Abs("./testdata/bundler/main.jsonnet")
+	require.NoError(t, err)
+	s := testServer(t, nil)
+
+	assert.Equal(t, []string{
+		filepath.Join(filepath.Dir(filename), "vendor"),
+		filepath.Join(filepath.Dir(filename), "lib"),
+		filepath.Dir(filename),
+	}, s.getJPaths(filename))
+
+	vm, err := s.getVM(filename)
+	require.NoError(t, err)
+	result, err := vm.EvaluateFile(filename)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"config": true, "project": true, "unlocked": true, "util": true}`, result)
+}
+
+func TestGetBundlerImportDiags(t *testing.T) {
+	s := testServer(t, nil)
+	doc, err := s.cache.get(serverOpenTestFile(t, s, "./testdata/bundler/main.jsonnet"))
+	require.NoError(t, err)
+
+	diags := s.getBundlerImportDiags(doc)
+	require.Len(t, diags, 1)
+	assert.Equal(t, uint32(1), diags[0].Range.Start.Line)
+	assert.Equal(t, protocol.SeverityWarning, diags[0].Severity)
+	assert.Contains(t, diags[0].Message, `the package of import "github.com/other/unlocked/lib.libsonnet" is missing from `)
+}
+
+func TestIsPackageImport(t *testing.T) {
+	filename, err := filepath.Abs("./testdata/bundler/main.jsonnet")
+	require.NoError(t, err)
+	project := findBundlerProject(filename)
+	require.NotNil(t, project)
+	jpath, err := filepath.Abs("./testdata/bundler-jpath")
+	require.NoError(t, err)
+	s := testServer(t, nil).WithStaticVM([]string{jpath})
+	searchPaths := s.importSearchPaths(filename)
+
+	for _, tc := range []struct {
+		importPath string
+		expected   bool
+	}{
+		{importPath: "github.com/other/unlocked/lib.libsonnet", expected: true},
+		{importPath: "github.com/other/missing/lib.libsonnet", expected: true},
+		{importPath: "example.com/missing/lib.libsonnet", expected: true},
+		{importPath: "config.d/settings.libsonnet", expected: false},
+		{importPath: "project.libsonnet", expected: false},
+		{importPath: "./config.d/settings.libsonnet", expected: false},
+		{importPath: "config.d/x.libsonnet", expected: false},
+		{importPath: "defaults.d/replicas.libsonnet", expected: false},
+		{importPath: "defaults.d/missing.libsonnet", expected: true},
+		{importPath: "github.com", expected: false},
+	} {
+		t.Run(tc.importPath, func(t *testing.T) {
+			assert.Equal(t, tc.expected, project.isPackageImport(tc.importPath, searchPaths))
+		})
+	}
+}
+
+func TestJsonnetfileHover(t *testing.T) {
+	s := testServer(t, nil)
+	uri := serverOpenTestFile(t, s, "./testdata/bundler/jsonnetfile.json")
+
+	for _, tc := range []struct {
+		name     string
+		position protocol.Position
+		expected string
+	}{
+		{
+			name:     "locked dependency",
+			position: protocol.Position{Line: 6, Character: 10},
+			expected: "**github.com/grafana/jsonnet-libs/ksonnet-util**\n\n" +
+				"- Locked version: `0123456789abcdef0123456789abcdef01234567`\n" +
+				"- Sum: `c8d3hOEZAbwLp2hV8Ov0Wm8sV+kYbVjQQmkC1Ze0cqg=`",
+		},
+		{
+			name:     "unlocked dependency",
+			position: protocol.Position{Line: 18, Character: 8},
+			expected: "**github.com/other/unlocked**\n\nNot locked, run `jb install`",
+		},
+		{
+			name:     "outside of dependencies",
+			position: protocol.Position{Line: 1, Character: 5},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hover, err := s.Hover(context.Background(), &protocol.HoverParams{
+				TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+					TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+					Position:     tc.position,
+				},
+			})
+			require.NoError(t, err)
+			if tc.expected == "" {
+				assert.Nil(t, hover)
+				return
+			}
+			require.NotNil(t, hover)
+			assert.Equal(t, tc.expected, hover.Contents.Value)
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/grafana/tanka/pkg/jsonnet/native"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+)
+
+// nativeCallRegexp matches the end of a line in the name of a `std.native` call, such as `std.native('parse`.
+var nativeCallRegexp = regexp.MustCompile(`std\.native\(\s*['"](\w*)$`)
+
+// tankaNativeDescriptions documents the native functions that Tanka registers.
+var tankaNativeDescriptions = map[string]string{
+	"parseJson":            "Parses a JSON string.",
+	"parseYaml":            "Parses a YAML string into an array of its documents.",
+	"manifestJsonFromJson": "Reformats a JSON string with the given indentation.",
+	"manifestYamlFromJson": "Converts a JSON string to YAML.",
+	"escapeStringRegex":    "Escapes the regular expression metacharacters of a string.",
+	"regexMatch":           "Returns whether a string matches a regular expression.",
+	"regexSubst":           "Replaces the matches of a regular expression in a string.",
+	"helmTemplate":         "Renders a Helm chart with `helm template`.",
+	"kustomizeBuild":       "Renders a Kustomization with `kustomize build`.",
+}
+
+// nativeStub is a native function declared in the settings. It returns a fixture value instead of
+// running the function, so that files calling it evaluate as they do in production.
+type nativeStub struct {
+	name        string
+	params      ast.Identifiers
+	result      interface{}
+	description string
+}
+
+func (n nativeStub) nativeFunction() *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Name:   n.name,
+		Params: n.params,
+		Func: func(args []interface{}) (interface{}, error) {
+			return n.result, nil
+		},
+	}
+}
+
+// nativeFunction describes a function that files can get with `std.native`.
+type nativeFunction struct {
+	name        string
+	params      ast.Identifiers
+	description string
+}
+
+func (n nativeFunction) signature() string {
+	params := make([]string, 0, len(n.params))
+	for _, param := range n.params {
+		params = append(params, string(param))
+	}
+	return fmt.Sprintf("std.native('%s')(%s)", n.name, strings.Join(params, ", "))
+}
+
+// registerNativeFunctions registers the native functions of the settings in a VM.
+// Tanka's native functions are registered by Tanka's VM, they are optional in static mode.
+func registerNativeFunctions(vm *jsonnet.VM, settings *folderSettings) {
+	if !settings.tankaMode && settings.tankaNatives {
+		for _, nf := range native.Funcs() {
+			vm.NativeFunction(nf)
+		}
+	}
+	for _, stub := range settings.nativeStubs {
+		vm.NativeFunction(stub.nativeFunction())
+	}
+}
+
+// nativeFunctionsFor returns the native functions available to a file, sorted by name.
+func (s *server) nativeFunctionsFor(path string) []nativeFunction {
+	settings := s.folderSettingsFor(path)
+
+	functions := map[string]nativeFunction{}
+	if settings.tankaMode || settings.tankaNatives {
+		for _, nf := range native.Funcs() {
+			functions[nf.Name] = nativeFunction{name: nf.Name, params: nf.Params, description: tankaNativeDescriptions[nf.Name]}
+		}
+	}
+	for _, stub := range settings.nativeStubs {
+		description := "Stub returning a configured value."
+		if stub.description != "" {
+			description = stub.description + "\n\n" + description
+		}
+		functions[stub.name] = nativeFunction{name: stub.name, params: stub.params, description: description}
+	}
+
+	sorted := make([]nativeFunction, 0, len(functions))
+	for _, function := range functions {
+		sorted = append(sorted, function)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].name < sorted[j].name })
+	return sorted
+}
+
+// nativeFunctionCompletion lists the native functions starting with the name being typed in a `std.native` call.
+// It returns false if the line, up to the cursor, does not end in the name of a `std.native` call.
+func (s *server) nativeFunctionCompletion(path, line string) ([]protocol.CompletionItem, bool) {
+	match := nativeCallRegexp.FindStringSubmatch(line)
+	if match == nil {
+		return nil, false
+	}
+
+	items := []protocol.CompletionItem{}
+	for _, function := range s.nativeFunctionsFor(path) {
+		if !strings.HasPrefix(strings.ToLower(function.name), strings.ToLower(match[1])) {
+			continue
+		}
+		items = append(items, protocol.CompletionItem{
+			Label:         function.name,
+			Kind:          protocol.FunctionCompletion,
+			Detail:        function.signature(),
+			Documentation: function.description,
+		})
+	}
+	return items, true
+}
+
+// nativeFunctionHover describes the native function named at a position, in a `std.native` call.
+func (s *server) nativeFunctionHover(root ast.Node, path string, pos protocol.Position) *protocol.Hover {
+	var name *ast.LiteralString
+	walkNodes(root, func(node ast.Node) {
+		if literal := nativeCallName(node); literal != nil && position.InRange(position.PositionProtocolToAST(pos), literal.LocRange) {
+			name = literal
+		}
+	})
+	if name == nil {
+		return nil
+	}
+
+	for _, function := range s.nativeFunctionsFor(path) {
+		if function.name == name.Value {
+			return &protocol.Hover{
+				Range: position.RangeASTToProtocol(name.LocRange),
+				Contents: protocol.MarkupContent{
+					Kind:  protocol.Markdown,
+					Value: fmt.Sprintf("`%s`\n\n%s", function.signature(), function.description),
+				},
+			}
+		}
+	}
+	return nil
+}
+
+// nativeCallName returns the name of the native function of a `std.native('name')` call.
+func nativeCallName(node ast.Node) *ast.LiteralString {
+	apply, ok := node.(*ast.Apply)
+	if !ok || len(apply.Arguments.Positional) != 1 {
+		return nil
+	}
+	index, ok := apply.Target.(*ast.Index)
+	if !ok {
+		return nil
+	}
+	target, ok := index.Target.(*ast.Var)
+	if !ok || target.Id != "std" {
+		return nil
+	}
+	if field, ok := index.Index.(*ast.LiteralString); !ok || field.Value != "native" {
+		return nil
+	}
+	name, _ := apply.Arguments.Positional[0].Expr.(*ast.LiteralString)
+	return name
+}
+
+// parseNativeStubs parses the native functions declared in the settings, such as:
+//
+//	{"lookupSecret": {"params": ["name"], "result": "secret", "description": "Reads a secret from Vault."}}
+func parseNativeStubs(key string, unparsed interface{}) ([]nativeStub, error) {
+	functions, ok := unparsed.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unsupported settings value for %s. expected json object. got: %T", key, unparsed)
+	}
+
+	stubs := make([]nativeStub, 0, len(functions))
+	for name, value := range functions {
+		settingsMap, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unsupported settings value for %s.%s. expected json object. got: %T", key, name, value)
+		}
+
+		stub := nativeStub{name: name}
+		for sk, sv := range settingsMap {
+			switch sk {
+			case "params":
+				params, err := parseStringList(fmt.Sprintf("%s.%s.params", key, name), sv)
+				if err != nil {
+					return nil, err
+				}
+				for _, param := range params {
+					stub.params = append(stub.params, ast.Identifier(param))
+				}
+			case "result":
+				stub.result = sv
+			case "description":
+				description, ok := sv.(string)
+				if !ok {
+					return nil, fmt.Errorf("unsupported settings value for %s.%s.description. expected string. got: %T", key, name, sv)
+				}
+				stub.description = description
+			default:
+				return nil, fmt.Errorf("unsupported settings key: \"%s.%s.%s\"", key, name, sk)
+			}
+		}
+		stubs = append(stubs, stub)
+	}
+	sort.Slice(stubs, func(i, j int) bool { return stubs[i].name < stubs[j].name })
+	return stubs, nil
+}
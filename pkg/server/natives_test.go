@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var nativeSettings = map[string]interface{}{
+	"tanka_natives": true,
+	"native_functions": map[string]interface{}{
+		"lookupSecret": map[string]interface{}{
+			"params":      []interface{}{"name"},
+			"result":      map[string]interface{}{"password": "dummy"},
+			"description": "Reads a secret from Vault.",
+		},
+	},
+}
+
+func TestNativeFunctions(t *testing.T) {
+	const snippet = `std.native('parseYaml')('a: 1')[0].a + std.length(std.native('lookupSecret')('db').password)`
+	s := testServer(t, nil)
+
+	vm, err := s.getVM("/tmp/main.jsonnet")
+	require.NoError(t, err)
+	_, err = vm.EvaluateAnonymousSnippet("/tmp/main.jsonnet", snippet)
+	assert.Error(t, err, "static mode registers no native functions by default")
+
+	require.NoError(t, s.changeConfiguration(context.Background(), nativeSettings))
+	vm, err = s.getVM("/tmp/main.jsonnet")
+	require.NoError(t, err)
+	result, err := vm.EvaluateAnonymousSnippet("/tmp/main.jsonnet", snippet)
+	require.NoError(t, err)
+	assert.Equal(t, "6\n", result)
+}
+
+func TestNativeFunctionsInvalidSettings(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		value       interface{}
+		expectedErr string
+	}{
+		{name: "not an object", value: "parseYaml", expectedErr: "unsupported settings value for native_functions. expected json object. got: string"},
+		{
+			name:        "invalid params",
+			value:       map[string]interface{}{"f": map[string]interface{}{"params": []interface{}{1.0}}},
+			expectedErr: "unsupported settings value for native_functions.f.params[0]. expected string. got: float64",
+		},
+		{
+			name:        "unknown key",
+			value:       map[string]interface{}{"f": map[string]interface{}{"returns": 1.0}},
+			expectedErr: `unsupported settings key: "native_functions.f.returns"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseNativeStubs("native_functions", tc.value)
+			assert.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestNativeFunctionCompletion(t *testing.T) {
+	s, uri := testServerWithFile(t, nil, "local parse = std.native('par")
+	require.NoError(t, s.changeConfiguration(context.Background(), nativeSettings))
+
+	result, err := s.Completion(context.Background(), &protocol.CompletionParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Position:     protocol.Position{Line: 0, Character: 29},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []protocol.CompletionItem{
+		{
+			Label:         "parseJson",
+			Kind:          protocol.FunctionCompletion,
+			Detail:        "std.native('parseJson')(json)",
+			Documentation: "Parses a JSON string.",
+		},
+		{
+			Label:         "parseYaml",
+			Kind:          protocol.FunctionCompletion,
+			Detail:        "std.native('parseYaml')(yaml)",
+			Documentation: "Parses a YAML string into an array of its documents.",
+		},
+	}, result.Items)
+}
+
+func TestNativeFunctionHover(t *testing.T) {
+	s, uri := testServerWithFile(t, nil, "{\n  secret: std.native('lookupSecret')('db'),\n}\n")
+	require.NoError(t, s.changeConfiguration(context.Background(), nativeSettings))
+
+	hover, err := s.Hover(context.Background(), &protocol.HoverParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Position:     protocol.Position{Line: 1, Character: 25},
+		},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, hover)
+	assert.Equal(t, "`std.native('lookupSecret')(name)`\n\nReads a secret from Vault.\n\nStub returning a configured value.", hover.Contents.Value)
+	assert.Equal(t, protocol.Range{
+		Start: protocol.Position{Line: 1, Character: 21},
+		End:   protocol.Position{Line: 1, Character: 35},
+	}, hover.Range)
+}
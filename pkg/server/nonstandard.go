@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jdbaldry/go-language-server-protocol/jsonrpc2"
+)
+
+// NonstandardRequest handles the requests that are unknown to the protocol library.
+// Their parameters are decoded as generic JSON so they are round-tripped into the request's type.
+func (s *server) NonstandardRequest(ctx context.Context, method string, params interface{}) (interface{}, error) {
+	switch method {
+	case inlayHintMethod:
+		var inlayHintParams InlayHintParams
+		if err := decodeParams(params, &inlayHintParams); err != nil {
+			return nil, err
+		}
+		return s.InlayHint(ctx, &inlayHintParams)
+	case inlineEnvironmentsMethod:
+		var inlineEnvironmentsParams InlineEnvironmentsParams
+		if err := decodeParams(params, &inlineEnvironmentsParams); err != nil {
+			return nil, err
+		}
+		return s.InlineEnvironments(ctx, &inlineEnvironmentsParams)
+	}
+
+	return nil, fmt.Errorf("%w: %s", jsonrpc2.ErrMethodNotFound, method)
+}
+
+func decodeParams(params interface{}, v interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("%w: %v", jsonrpc2.ErrInvalidParams, err)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("%w: %v", jsonrpc2.ErrInvalidParams, err)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+// projectConfigFileNames are the project config files looked up at the root of workspace folders, in order.
+// They accept the keys of the settings that can differ between workspace folders.
+var projectConfigFileNames = []string{".jsonnet-language-server.json", ".jsonnetls.yaml", ".jsonnetls.yml"}
+
+// watchProjectConfigs asks the client to notify changes of the project config files.
+func (s *server) watchProjectConfigs(ctx context.Context) {
+	watchers := make([]protocol.FileSystemWatcher, 0, len(projectConfigFileNames))
+	for _, name := range projectConfigFileNames {
+		watchers = append(watchers, protocol.FileSystemWatcher{GlobPattern: "**/" + name})
+	}
+	err := s.client.RegisterCapability(ctx, &protocol.RegistrationParams{
+		Registrations: []protocol.Registration{{
+			ID:              didChangeWatchedFilesMethod,
+			Method:          didChangeWatchedFilesMethod,
+			RegisterOptions: protocol.DidChangeWatchedFilesRegistrationOptions{Watchers: watchers},
+		}},
+	})
+	if err != nil {
+		log.Warnf("Unable to watch the project config files: %v", err)
+	}
+}
+
+func (s *server) DidChangeWatchedFiles(ctx context.Context, params *protocol.DidChangeWatchedFilesParams) error {
+	for _, change := range params.Changes {
+		path := change.URI.SpanURI().Filename()
+		if !isProjectConfigFile(path) {
+			continue
+		}
+		for _, folder := range s.workspaceFolders() {
+			if filepath.Dir(path) == folder.path {
+				s.reloadProjectConfig(ctx, folder)
+			}
+		}
+	}
+	return nil
+}
+
+func isProjectConfigFile(path string) bool {
+	for _, name := range projectConfigFileNames {
+		if filepath.Base(path) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadProjectConfig reads the project config file of a workspace folder and applies it over the settings of the folder.
+// Problems are published as diagnostics of the file, the valid settings are still applied.
+func (s *server) reloadProjectConfig(ctx context.Context, folder *workspaceFolder) {
+	path, settings, diags := loadProjectConfig(folder.path)
+	if path != "" {
+		log.Infof("Using the project config file %s", path)
+	}
+
+	s.foldersMu.Lock()
+	previousPath := folder.projectConfigPath
+	folder.projectConfigPath, folder.projectSettings = path, settings
+	s.resolveFolderSettings(folder)
+	s.foldersMu.Unlock()
+	s.settingsChanged()
+
+	// Always publish, so that the diagnostics are cleared once the file is fixed or removed
+	if previousPath != "" && previousPath != path {
+		s.publishProjectConfigDiags(ctx, previousPath, []protocol.Diagnostic{})
+	}
+	if path != "" {
+		s.publishProjectConfigDiags(ctx, path, diags)
+	}
+}
+
+func (s *server) publishProjectConfigDiags(ctx context.Context, path string, diags []protocol.Diagnostic) {
+	err := s.client.PublishDiagnostics(ctx, &protocol.PublishDiagnosticsParams{
+		URI:         protocol.URIFromPath(path),
+		Diagnostics: diags,
+	})
+	if err != nil {
+		log.Errorf("publishProjectConfigDiags: unable to publish diagnostics: %v\n", err)
+	}
+}
+
+// loadProjectConfig reads the first project config file found in a directory.
+// It returns an empty path if there is none, and the problems of the file as diagnostics.
+func loadProjectConfig(dir string) (path string, settings map[string]interface{}, diags []protocol.Diagnostic) {
+	var content []byte
+	for _, name := range projectConfigFileNames {
+		var err error
+		path = filepath.Join(dir, name)
+		content, err = os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			path = ""
+			continue
+		}
+		if err != nil {
+			return path, nil, []protocol.Diagnostic{projectConfigDiag(protocol.Range{}, err.Error())}
+		}
+		break
+	}
+	if path == "" {
+		return "", nil, nil
+	}
+
+	jsonContent := content
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		var err error
+		if jsonContent, err = yaml.YAMLToJSON(content); err != nil {
+			return path, nil, []protocol.Diagnostic{projectConfigDiag(protocol.Range{}, err.Error())}
+		}
+	}
+
+	var unparsed interface{}
+	if err := json.Unmarshal(jsonContent, &unparsed); err != nil {
+		diag := projectConfigDiag(protocol.Range{}, err.Error())
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			// The offset points right after the invalid character
+			line, col := offsetToPosition(content, syntaxErr.Offset-1)
+			diag.Range = position.NewProtocolRange(line, col, line, col+1)
+		}
+		return path, nil, []protocol.Diagnostic{diag}
+	}
+	settings, ok := unparsed.(map[string]interface{})
+	if !ok {
+		return path, nil, []protocol.Diagnostic{projectConfigDiag(protocol.Range{}, fmt.Sprintf("unsupported settings payload. expected json object, got: %T", unparsed))}
+	}
+
+	// Keys are validated on their own, the settings they apply over are not known yet
+	for _, key := range sortedSettingsKeys(settings) {
+		apply, ok := folderSettingsSchema[key]
+		if !ok {
+			diags = append(diags, projectConfigDiag(projectConfigKeyRange(content, key), fmt.Sprintf("unsupported settings key: %q", key)))
+			continue
+		}
+		if err := apply(&folderSettings{}, dir, key, settings[key]); err != nil {
+			diags = append(diags, projectConfigDiag(projectConfigKeyRange(content, key), err.Error()))
+		}
+	}
+	return path, settings, diags
+}
+
+func projectConfigDiag(rng protocol.Range, message string) protocol.Diagnostic {
+	return protocol.Diagnostic{
+		Range:    rng,
+		Severity: protocol.SeverityError,
+		Source:   "project config",
+		Message:  message,
+	}
+}
+
+// projectConfigKeyRange returns the range of the first occurrence of a key in a JSON or YAML file.
+func projectConfigKeyRange(content []byte, key string) protocol.Range {
+	quoted, _ := json.Marshal(key)
+	offset := bytes.Index(content, quoted)
+	length := len(quoted)
+	if offset < 0 {
+		offset, length = bytes.Index(content, []byte(key+":")), len(key)
+	}
+	if offset < 0 {
+		return protocol.Range{}
+	}
+	line, col := offsetToPosition(content, int64(offset))
+	return position.NewProtocolRange(line, col, line, col+length)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-jsonnet/formatter"
+	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProjectConfig(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "lib"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "lib", "lib.libsonnet"), []byte(`{ name: 'lib' }`), 0o600))
+	jsonConfig := filepath.Join(root, ".jsonnet-language-server.json")
+	require.NoError(t, os.WriteFile(jsonConfig, []byte(`{
+  "jpath": ["lib"],
+  "ext_vars": { "greeting": "json" },
+  "formatting": { "indent": 4 },
+  "exclude": ["generated/**"],
+  "log_level": "debug"
+}`), 0o600))
+
+	client := &configurationClient{}
+	s := NewServer("jsonnet-language-server", "dev", client).WithStaticVM([]string{})
+	params := &protocol.ParamInitialize{}
+	params.WorkspaceFolders = []protocol.WorkspaceFolder{{URI: string(protocol.URIFromPath(root)), Name: "project"}}
+	_, err := s.Initialize(context.Background(), params)
+	require.NoError(t, err)
+	require.NoError(t, s.Initialized(context.Background(), &protocol.InitializedParams{}))
+
+	mainPath := filepath.Join(root, "main.jsonnet")
+	evaluate := func() (string, error) {
+		vm, err := s.getVM(mainPath)
+		require.NoError(t, err)
+		return vm.EvaluateAnonymousSnippet(mainPath, `(import 'lib.libsonnet').name + std.extVar('greeting')`)
+	}
+
+	// Settings of the file apply to the files of the folder
+	value, err := evaluate()
+	require.NoError(t, err)
+	assert.Equal(t, "\"libjson\"\n", value)
+	assert.Equal(t, 4, s.folderSettingsFor(mainPath).fmtOpts.Indent)
+	assert.True(t, s.folderSettingsFor(mainPath).diagnosed(mainPath))
+	assert.False(t, s.folderSettingsFor(mainPath).diagnosed(filepath.Join(root, "generated", "main.jsonnet")))
+
+	// Settings that cannot differ between folders are reported in the file
+	assert.Equal(t, []protocol.Diagnostic{{
+		Range:    position.NewProtocolRange(5, 2, 5, 13),
+		Severity: protocol.SeverityError,
+		Source:   "project config",
+		Message:  `unsupported settings key: "log_level"`,
+	}}, client.publishedDiagnostics(protocol.URIFromPath(jsonConfig)))
+
+	// Changes of the files are picked up
+	yamlConfig := filepath.Join(root, ".jsonnetls.yaml")
+	require.NoError(t, os.Remove(jsonConfig))
+	require.NoError(t, os.WriteFile(yamlConfig, []byte("jpath: [lib]\next_vars:\n  greeting: yaml\n"), 0o600))
+	require.NoError(t, s.DidChangeWatchedFiles(context.Background(), &protocol.DidChangeWatchedFilesParams{
+		Changes: []protocol.FileEvent{
+			{URI: protocol.URIFromPath(jsonConfig), Type: protocol.Deleted},
+			{URI: protocol.URIFromPath(yamlConfig), Type: protocol.Created},
+		},
+	}))
+	value, err = evaluate()
+	require.NoError(t, err)
+	assert.Equal(t, "\"libyaml\"\n", value)
+	assert.Equal(t, formatter.DefaultOptions().Indent, s.folderSettingsFor(mainPath).fmtOpts.Indent)
+	assert.Empty(t, client.publishedDiagnostics(protocol.URIFromPath(jsonConfig)), "diagnostics of removed files are cleared")
+	assert.Empty(t, client.publishedDiagnostics(protocol.URIFromPath(yamlConfig)))
+
+	// Without a file, the folder uses the global settings
+	require.NoError(t, os.Remove(yamlConfig))
+	require.NoError(t, s.DidChangeWatchedFiles(context.Background(), &protocol.DidChangeWatchedFilesParams{
+		Changes: []protocol.FileEvent{{URI: protocol.URIFromPath(yamlConfig), Type: protocol.Deleted}},
+	}))
+	_, err = evaluate()
+	assert.Error(t, err)
+}
+
+func TestProjectConfigSyntaxError(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, ".jsonnet-language-server.json"), []byte("{\n  \"jpath\": [\"lib\"],,\n}"), 0o600))
+
+	_, settings, diags := loadProjectConfig(root)
+	assert.Nil(t, settings)
+	require.Len(t, diags, 1)
+	assert.Equal(t, position.NewProtocolRange(1, 19, 1, 20), diags[0].Range)
+}
+
+func TestProjectConfigYmlExtension(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, ".jsonnetls.yml")
+	require.NoError(t, os.WriteFile(path, []byte("jpath: [lib]\n"), 0o600))
+
+	loadedPath, settings, diags := loadProjectConfig(root)
+	assert.Equal(t, path, loadedPath)
+	assert.Equal(t, map[string]interface{}{"jpath": []interface{}{"lib"}}, settings)
+	assert.Empty(t, diags)
+	assert.True(t, isProjectConfigFile(path))
+}
+
+func TestProjectConfigWatchRegistration(t *testing.T) {
+	for _, dynamicRegistration := range []bool{false, true} {
+		client := &configurationClient{}
+		s := NewServer("jsonnet-language-server", "dev", client).WithStaticVM([]string{})
+		params := &protocol.ParamInitialize{}
+		params.Capabilities.Workspace.DidChangeWatchedFiles.DynamicRegistration = dynamicRegistration
+		_, err := s.Initialize(context.Background(), params)
+		require.NoError(t, err)
+		require.NoError(t, s.Initialized(context.Background(), &protocol.InitializedParams{}))
+
+		var watched []string
+		for _, registration := range client.registrations {
+			if registration.Method == didChangeWatchedFilesMethod {
+				for _, watcher := range registration.RegisterOptions.(protocol.DidChangeWatchedFilesRegistrationOptions).Watchers {
+					watched = append(watched, watcher.GlobPattern)
+				}
+			}
+		}
+		if dynamicRegistration {
+			assert.Equal(t, []string{"**/.jsonnet-language-server.json", "**/.jsonnetls.yaml", "**/.jsonnetls.yml"}, watched)
+		} else {
+			assert.Empty(t, watched)
+		}
+	}
+}
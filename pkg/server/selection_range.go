@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/grafana/jsonnet-language-server/pkg/processing"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *server) SelectionRange(ctx context.Context, params *protocol.SelectionRangeParams) ([]protocol.SelectionRange, error) {
+	doc, err := s.cache.get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("SelectionRange: %s: %w", errorRetrievingDocument, err)
+	}
+
+	if doc.ast == nil {
+		log.Error("SelectionRange: error parsing the document")
+		return nil, nil
+	}
+
+	var result []protocol.SelectionRange
+	for _, pos := range params.Positions {
+		selectionRange, err := findSelectionRange(doc.ast, doc.item.Text, pos)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *selectionRange)
+	}
+	return result, nil
+}
+
+// findSelectionRange returns the chain of ranges enclosing the given position, from the innermost expression to the whole file.
+// On top of the nodes, object fields (name and value) and local binds (name and body) are part of the chain.
+func findSelectionRange(root ast.Node, text string, pos protocol.Position) (*protocol.SelectionRange, error) {
+	location := position.PositionProtocolToAST(pos)
+	stack, err := processing.FindNodeByPosition(root, location)
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []ast.LocationRange
+	addRange := func(r ast.LocationRange) {
+		if r.IsSet() && position.InRange(location, r) {
+			ranges = append(ranges, r)
+		}
+	}
+	addBinds := func(binds []ast.LocalBind) {
+		for _, bind := range binds {
+			addRange(bind.LocRange)
+		}
+	}
+	for _, node := range stack.Stack {
+		addRange(*node.Loc())
+		switch node := node.(type) {
+		case *ast.Local:
+			addBinds(node.Binds)
+		case *ast.DesugaredObject:
+			addBinds(node.Locals)
+			for _, field := range node.Fields {
+				addRange(field.LocRange)
+			}
+		}
+	}
+
+	// Outermost first. Ranges that do not contain the following ones (siblings) are dropped
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].Begin != ranges[j].Begin {
+			return ast.LocationBefore(ranges[i].Begin, ranges[j].Begin)
+		}
+		return ast.LocationBefore(ranges[j].End, ranges[i].End)
+	})
+	lines := strings.Split(text, "\n")
+	current := &protocol.SelectionRange{
+		Range: position.NewProtocolRange(0, 0, len(lines)-1, len(lines[len(lines)-1])),
+	}
+	var last *ast.LocationRange
+	for i := range ranges {
+		r := ranges[i]
+		if last != nil && (!position.RangeGreaterOrEqual(*last, r) || *last == r) {
+			continue
+		}
+		protocolRange := position.RangeASTToProtocol(r)
+		if protocolRange == current.Range {
+			continue
+		}
+		current = &protocol.SelectionRange{Range: protocolRange, Parent: current}
+		last = &r
+	}
+	return current, nil
+}
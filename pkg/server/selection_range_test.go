@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectionRange(t *testing.T) {
+	// The outer ranges are the same for every position of folding.jsonnet:
+	// the root object, the three import locals, the helper local and the whole file
+	rootRanges := []protocol.Range{
+		{Start: protocol.Position{Line: 12, Character: 0}, End: protocol.Position{Line: 32, Character: 1}},
+		{Start: protocol.Position{Line: 8, Character: 0}, End: protocol.Position{Line: 32, Character: 1}},
+		{Start: protocol.Position{Line: 2, Character: 0}, End: protocol.Position{Line: 32, Character: 1}},
+		{Start: protocol.Position{Line: 1, Character: 0}, End: protocol.Position{Line: 32, Character: 1}},
+		{Start: protocol.Position{Line: 0, Character: 0}, End: protocol.Position{Line: 32, Character: 1}},
+		{Start: protocol.Position{Line: 0, Character: 0}, End: protocol.Position{Line: 33, Character: 0}},
+	}
+
+	testCases := []struct {
+		name     string
+		position protocol.Position
+		expected []protocol.Range
+	}{
+		{
+			name:     "nested field value",
+			position: protocol.Position{Line: 15, Character: 16},
+			expected: append([]protocol.Range{
+				{Start: protocol.Position{Line: 15, Character: 12}, End: protocol.Position{Line: 15, Character: 39}}, // 'value'
+				{Start: protocol.Position{Line: 15, Character: 4}, End: protocol.Position{Line: 15, Character: 39}},  // nested: 'value'
+				{Start: protocol.Position{Line: 14, Character: 7}, End: protocol.Position{Line: 16, Character: 3}},   // { nested }
+				{Start: protocol.Position{Line: 14, Character: 2}, End: protocol.Position{Line: 16, Character: 3}},   // obj: { nested }
+			}, rootRanges...),
+		},
+		{
+			name:     "local function body",
+			position: protocol.Position{Line: 10, Character: 4},
+			expected: []protocol.Range{
+				{Start: protocol.Position{Line: 10, Character: 4}, End: protocol.Position{Line: 10, Character: 5}}, // 1
+				{Start: protocol.Position{Line: 9, Character: 2}, End: protocol.Position{Line: 10, Character: 5}},  // x + 1
+				{Start: protocol.Position{Line: 8, Character: 6}, End: protocol.Position{Line: 10, Character: 5}},  // helper(x) = x + 1
+				{Start: protocol.Position{Line: 8, Character: 0}, End: protocol.Position{Line: 32, Character: 1}},
+				{Start: protocol.Position{Line: 2, Character: 0}, End: protocol.Position{Line: 32, Character: 1}},
+				{Start: protocol.Position{Line: 1, Character: 0}, End: protocol.Position{Line: 32, Character: 1}},
+				{Start: protocol.Position{Line: 0, Character: 0}, End: protocol.Position{Line: 32, Character: 1}},
+				{Start: protocol.Position{Line: 0, Character: 0}, End: protocol.Position{Line: 33, Character: 0}},
+			},
+		},
+		{
+			name:     "array comprehension",
+			position: protocol.Position{Line: 23, Character: 14},
+			expected: append([]protocol.Range{
+				{Start: protocol.Position{Line: 23, Character: 14}, End: protocol.Position{Line: 23, Character: 15}}, // 1
+				{Start: protocol.Position{Line: 23, Character: 13}, End: protocol.Position{Line: 23, Character: 19}}, // [1, 2]
+				{Start: protocol.Position{Line: 21, Character: 8}, End: protocol.Position{Line: 24, Character: 3}},   // comprehension
+				{Start: protocol.Position{Line: 21, Character: 2}, End: protocol.Position{Line: 24, Character: 3}},   // comp: [...]
+			}, rootRanges...),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := testServer(t, nil)
+			uri := serverOpenTestFile(t, s, "./testdata/folding.jsonnet")
+
+			result, err := s.SelectionRange(context.Background(), &protocol.SelectionRangeParams{
+				TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+				Positions:    []protocol.Position{tc.position},
+			})
+			require.NoError(t, err)
+			require.Len(t, result, 1)
+
+			var got []protocol.Range
+			for r := &result[0]; r != nil; r = r.Parent {
+				got = append(got, r.Range)
+			}
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
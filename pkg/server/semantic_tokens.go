@@ -0,0 +1,370 @@
+package server
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// Semantic token types. The order must match semanticTokenTypes.
+const (
+	tokenNamespace uint32 = iota
+	tokenVariable
+	tokenParameter
+	tokenProperty
+	tokenMethod
+	tokenFunction
+	tokenKeyword
+	tokenString
+	tokenFormatSpecifier
+)
+
+// Semantic token modifiers. These are bit flags, the order must match semanticTokenModifiers.
+const (
+	modifierDeclaration uint32 = 1 << iota
+	modifierDefaultLibrary
+	modifierHidden
+	modifierPlusSuper
+	modifierImport
+)
+
+var (
+	semanticTokenTypes     = []string{"namespace", "variable", "parameter", "property", "method", "function", "keyword", "string", "formatSpecifier"}
+	semanticTokenModifiers = []string{"declaration", "defaultLibrary", "hidden", "plusSuper", "import"}
+
+	// formatSpecifierRegexp matches the Python-style placeholders supported by std.format.
+	formatSpecifierRegexp = regexp.MustCompile(`%(\([^)]*\))?[#0\- +]*(\*|\d+)?(\.(\*|\d+))?[hlL]?[diouxXeEfFgGcrs%]`)
+)
+
+type semanticToken struct {
+	line, col, length uint32
+	tokenType         uint32
+	modifiers         uint32
+}
+
+func (s *server) SemanticTokensFull(ctx context.Context, params *protocol.SemanticTokensParams) (*protocol.SemanticTokens, error) {
+	doc, err := s.cache.get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("SemanticTokensFull: %s: %w", errorRetrievingDocument, err)
+	}
+
+	if doc.ast == nil {
+		log.Error("SemanticTokensFull: error parsing the document")
+		return nil, nil
+	}
+
+	result := &protocol.SemanticTokens{
+		ResultID: strconv.Itoa(int(doc.item.Version)),
+		Data:     encodeSemanticTokens(findSemanticTokens(doc.ast, doc.item.Text)),
+	}
+	s.cache.putSemanticTokens(params.TextDocument.URI, result)
+	return result, nil
+}
+
+func (s *server) SemanticTokensFullDelta(ctx context.Context, params *protocol.SemanticTokensDeltaParams) (interface{}, error) {
+	if _, err := s.cache.get(params.TextDocument.URI); err != nil {
+		return nil, utils.LogErrorf("SemanticTokensFullDelta: %s: %w", errorRetrievingDocument, err)
+	}
+
+	previous := s.cache.getSemanticTokens(params.TextDocument.URI)
+	current, err := s.SemanticTokensFull(ctx, &protocol.SemanticTokensParams{TextDocument: params.TextDocument})
+	if err != nil || current == nil {
+		return current, err
+	}
+
+	// The client's tokens are unknown, send all of them
+	if previous == nil || previous.ResultID != params.PreviousResultID {
+		return current, nil
+	}
+
+	return &protocol.SemanticTokensDelta{
+		ResultID: current.ResultID,
+		Edits:    diffSemanticTokens(previous.Data, current.Data),
+	}, nil
+}
+
+func (s *server) SemanticTokensRange(ctx context.Context, params *protocol.SemanticTokensRangeParams) (*protocol.SemanticTokens, error) {
+	doc, err := s.cache.get(params.TextDocument.URI)
+	if err != nil {
+		return nil, utils.LogErrorf("SemanticTokensRange: %s: %w", errorRetrievingDocument, err)
+	}
+
+	if doc.ast == nil {
+		log.Error("SemanticTokensRange: error parsing the document")
+		return nil, nil
+	}
+
+	var tokens []semanticToken
+	for _, token := range findSemanticTokens(doc.ast, doc.item.Text) {
+		start := protocol.Position{Line: token.line, Character: token.col}
+		end := protocol.Position{Line: token.line, Character: token.col + token.length}
+		if !positionBefore(start, params.Range.Start) && !positionBefore(params.Range.End, end) {
+			tokens = append(tokens, token)
+		}
+	}
+
+	return &protocol.SemanticTokens{Data: encodeSemanticTokens(tokens)}, nil
+}
+
+func positionBefore(a, b protocol.Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+}
+
+// encodeSemanticTokens sorts the tokens and encodes them relative to each other, as defined by the LSP spec.
+func encodeSemanticTokens(tokens []semanticToken) []uint32 {
+	sort.SliceStable(tokens, func(i, j int) bool {
+		if tokens[i].line != tokens[j].line {
+			return tokens[i].line < tokens[j].line
+		}
+		return tokens[i].col < tokens[j].col
+	})
+
+	data := []uint32{}
+	var prevLine, prevCol, prevEnd uint32
+	for i, token := range tokens {
+		// Overlapping tokens are not supported, the first one wins
+		if i > 0 && token.line == prevLine && token.col < prevEnd {
+			continue
+		}
+		deltaCol := token.col
+		if token.line == prevLine {
+			deltaCol -= prevCol
+		}
+		data = append(data, token.line-prevLine, deltaCol, token.length, token.tokenType, token.modifiers)
+		prevLine, prevCol, prevEnd = token.line, token.col, token.col+token.length
+	}
+	return data
+}
+
+// diffSemanticTokens returns a single edit that replaces the part that differs between the two token arrays.
+func diffSemanticTokens(before, after []uint32) []protocol.SemanticTokensEdit {
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix && before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+
+	if prefix == len(before) && prefix == len(after) {
+		return []protocol.SemanticTokensEdit{}
+	}
+	return []protocol.SemanticTokensEdit{{
+		Start:       uint32(prefix),
+		DeleteCount: uint32(len(before) - prefix - suffix),
+		Data:        after[prefix : len(after)-suffix],
+	}}
+}
+
+// findSemanticTokens walks the AST and returns tokens for identifiers whose meaning depends on their scope:
+// locals, parameters, fields, std functions, self, super, $, import paths and format string placeholders.
+func findSemanticTokens(root ast.Node, text string) []semanticToken {
+	lines := strings.Split(text, "\n")
+	var tokens []semanticToken
+
+	addToken := func(begin ast.Location, length int, tokenType, modifiers uint32) {
+		if !begin.IsSet() || length <= 0 || begin.Line > len(lines) {
+			return
+		}
+		tokens = append(tokens, semanticToken{
+			line:      uint32(begin.Line - 1),
+			col:       uint32(begin.Column - 1),
+			length:    uint32(length),
+			tokenType: tokenType,
+			modifiers: modifiers,
+		})
+	}
+	sourceAt := func(loc ast.Location) string {
+		if !loc.IsSet() || loc.Line > len(lines) || loc.Column-1 > len(lines[loc.Line-1]) {
+			return ""
+		}
+		return lines[loc.Line-1][loc.Column-1:]
+	}
+	// Index names written with a dot (`a.b`) do not have a location, they end the index's range
+	addIndexToken := func(node ast.Node, index ast.Node, tokenType, modifiers uint32) {
+		name, ok := index.(*ast.LiteralString)
+		if !ok || name.Loc().IsSet() || node.Loc().Begin.Line != node.Loc().End.Line {
+			return
+		}
+		begin := node.Loc().End
+		begin.Column -= len(name.Value)
+		if strings.HasPrefix(sourceAt(begin), name.Value) {
+			addToken(begin, len(name.Value), tokenType, modifiers)
+		}
+	}
+
+	var walk func(node ast.Node, scope map[ast.Identifier]uint32)
+	withBinds := func(scope map[ast.Identifier]uint32, binds ast.LocalBinds) map[ast.Identifier]uint32 {
+		newScope := make(map[ast.Identifier]uint32, len(scope)+len(binds))
+		for k, v := range scope {
+			newScope[k] = v
+		}
+		for _, bind := range binds {
+			tokenType := tokenVariable
+			if _, ok := bind.Body.(*ast.Function); ok {
+				tokenType = tokenFunction
+			}
+			newScope[bind.Variable] = tokenType
+		}
+		return newScope
+	}
+	walkBinds := func(binds ast.LocalBinds, scope map[ast.Identifier]uint32) {
+		for _, bind := range binds {
+			begin := bind.LocRange.Begin
+			if !begin.IsSet() {
+				begin = bind.Body.Loc().Begin
+			}
+			if strings.HasPrefix(sourceAt(begin), string(bind.Variable)) {
+				addToken(begin, len(bind.Variable), scope[bind.Variable], modifierDeclaration)
+			}
+			walk(bind.Body, scope)
+		}
+	}
+
+	walk = func(node ast.Node, scope map[ast.Identifier]uint32) {
+		if node == nil {
+			return
+		}
+		switch node := node.(type) {
+		case *ast.Local:
+			scope = withBinds(scope, node.Binds)
+			walkBinds(node.Binds, scope)
+			walk(node.Body, scope)
+			return
+
+		case *ast.Function:
+			newScope := make(map[ast.Identifier]uint32, len(scope)+len(node.Parameters))
+			for k, v := range scope {
+				newScope[k] = v
+			}
+			for _, param := range node.Parameters {
+				newScope[param.Name] = tokenParameter
+				addToken(param.LocRange.Begin, len(param.Name), tokenParameter, modifierDeclaration)
+			}
+			for _, param := range node.Parameters {
+				walk(param.DefaultArg, newScope)
+			}
+			walk(node.Body, newScope)
+			return
+
+		case *ast.DesugaredObject:
+			scope = withBinds(scope, node.Locals)
+			walkBinds(node.Locals, scope)
+			for _, field := range node.Fields {
+				name, isString := field.Name.(*ast.LiteralString)
+				if !isString || strings.HasPrefix(sourceAt(field.LocRange.Begin), "[") {
+					// Computed field name
+					walk(field.Name, scope)
+				} else {
+					tokenType, modifiers := tokenProperty, uint32(0)
+					if _, isFunc := field.Body.(*ast.Function); isFunc {
+						tokenType = tokenMethod
+					}
+					if field.Hide == ast.ObjectFieldHidden {
+						modifiers |= modifierHidden
+					}
+					if field.PlusSuper {
+						modifiers |= modifierPlusSuper
+					}
+					if name.Loc().IsSet() {
+						// Quoted field name
+						addToken(name.Loc().Begin, name.Loc().End.Column-name.Loc().Begin.Column, tokenType, modifiers|modifierDeclaration)
+					} else if strings.HasPrefix(sourceAt(field.LocRange.Begin), name.Value) {
+						addToken(field.LocRange.Begin, len(name.Value), tokenType, modifiers|modifierDeclaration)
+					}
+				}
+				walk(field.Body, scope)
+			}
+			for _, assert := range node.Asserts {
+				walk(assert, scope)
+			}
+			return
+
+		case *ast.Var:
+			switch node.Id {
+			case "std":
+				addToken(node.Loc().Begin, len(node.Id), tokenNamespace, modifierDefaultLibrary)
+			case "$":
+				addToken(node.Loc().Begin, len(node.Id), tokenKeyword, 0)
+			default:
+				if tokenType, ok := scope[node.Id]; ok {
+					addToken(node.Loc().Begin, len(node.Id), tokenType, 0)
+				}
+			}
+
+		case *ast.Self:
+			addToken(node.Loc().Begin, len("self"), tokenKeyword, 0)
+
+		case *ast.SuperIndex:
+			addToken(node.Loc().Begin, len("super"), tokenKeyword, 0)
+			if name, ok := node.Index.(*ast.LiteralString); ok && !name.Loc().IsSet() {
+				// The location of the node may have been extended to the index by processing.FindNodeByPosition
+				begin := node.Loc().Begin
+				begin.Column += len("super.")
+				if strings.HasPrefix(sourceAt(begin), name.Value) {
+					addToken(begin, len(name.Value), tokenProperty, 0)
+				}
+			}
+
+		case *ast.InSuper:
+			if end := node.Loc().End; strings.HasPrefix(sourceAt(ast.Location{Line: end.Line, Column: end.Column - len("super")}), "super") {
+				addToken(ast.Location{Line: end.Line, Column: end.Column - len("super")}, len("super"), tokenKeyword, 0)
+			}
+
+		case *ast.Index:
+			if target, ok := node.Target.(*ast.Var); ok && target.Id == "std" {
+				addIndexToken(node, node.Index, tokenFunction, modifierDefaultLibrary)
+			} else {
+				addIndexToken(node, node.Index, tokenProperty, 0)
+			}
+
+		case *ast.Import:
+			addToken(node.File.Loc().Begin, node.File.Loc().End.Column-node.File.Loc().Begin.Column, tokenString, modifierImport)
+		case *ast.ImportStr:
+			addToken(node.File.Loc().Begin, node.File.Loc().End.Column-node.File.Loc().Begin.Column, tokenString, modifierImport)
+
+		case *ast.Apply:
+			if isFormatCall(node) {
+				if format, ok := node.Arguments.Positional[0].Expr.(*ast.LiteralString); ok {
+					loc := format.Loc()
+					if loc.IsSet() && loc.Begin.Line == loc.End.Line {
+						source := sourceAt(loc.Begin)[:loc.End.Column-loc.Begin.Column]
+						for _, match := range formatSpecifierRegexp.FindAllStringIndex(source, -1) {
+							addToken(ast.Location{Line: loc.Begin.Line, Column: loc.Begin.Column + match[0]}, match[1]-match[0], tokenFormatSpecifier, 0)
+						}
+					}
+				}
+			}
+		}
+
+		for _, child := range toolutils.Children(node) {
+			walk(child, scope)
+		}
+	}
+	walk(root, map[ast.Identifier]uint32{})
+
+	return tokens
+}
+
+// isFormatCall checks if a call formats a string: `std.format(fmt, vals)`, or `fmt % vals` which is desugared
+// into `std.mod(fmt, vals)`. Other operators are also desugared into calls of the standard library.
+func isFormatCall(node *ast.Apply) bool {
+	index, ok := node.Target.(*ast.Index)
+	if !ok || len(node.Arguments.Positional) != 2 {
+		return false
+	}
+	if target, ok := index.Target.(*ast.Var); !ok || (target.Id != "std" && target.Id != "$std") {
+		return false
+	}
+	name, ok := index.Index.(*ast.LiteralString)
+	return ok && (name.Value == "format" || name.Value == "mod")
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type decodedToken struct {
+	line, col, length uint32
+	tokenType         string
+	modifiers         uint32
+}
+
+func decodeSemanticTokens(data []uint32) []decodedToken {
+	var result []decodedToken
+	var line, col uint32
+	for i := 0; i+4 < len(data); i += 5 {
+		if data[i] > 0 {
+			col = 0
+		}
+		line += data[i]
+		col += data[i+1]
+		result = append(result, decodedToken{line, col, data[i+2], semanticTokenTypes[data[i+3]], data[i+4]})
+	}
+	return result
+}
+
+var semanticTokensTestFileTokens = []decodedToken{
+	{0, 6, 3, "variable", modifierDeclaration},                     // lib
+	{0, 19, 27, "string", modifierImport},                          // import path
+	{1, 6, 3, "function", modifierDeclaration},                     // add
+	{1, 10, 1, "parameter", modifierDeclaration},                   // a
+	{1, 13, 1, "parameter", modifierDeclaration},                   // b
+	{1, 20, 1, "parameter", 0},                                     // a
+	{1, 24, 1, "parameter", 0},                                     // b
+	{3, 2, 6, "property", modifierDeclaration | modifierHidden},    // hidden::
+	{3, 11, 3, "namespace", modifierDefaultLibrary},                // std
+	{3, 15, 6, "function", modifierDefaultLibrary},                 // length
+	{4, 2, 8, "property", modifierDeclaration | modifierPlusSuper}, // 'quoted'+:
+	{4, 13, 4, "keyword", 0},                                       // self
+	{4, 18, 6, "property", 0},                                      // hidden
+	{4, 27, 1, "keyword", 0},                                       // $
+	{4, 29, 6, "property", 0},                                      // hidden
+	{4, 38, 3, "variable", 0},                                      // lib
+	{4, 42, 3, "property", 0},                                      // bar
+	{5, 2, 6, "method", modifierDeclaration},                       // method
+	{5, 9, 1, "parameter", modifierDeclaration},                    // x
+	{5, 13, 5, "keyword", 0},                                       // super
+	{5, 19, 6, "property", 0},                                      // method
+	{5, 26, 1, "parameter", 0},                                     // x
+	{5, 34, 2, "formatSpecifier", 0},                               // %s
+	{5, 37, 7, "formatSpecifier", 0},                               // %(b)05d
+	{5, 49, 1, "parameter", 0},                                     // x
+	{6, 8, 1, "variable", modifierDeclaration},                     // l
+	{7, 2, 5, "property", modifierDeclaration},                     // field
+	{7, 9, 1, "variable", 0},                                       // l
+	{7, 13, 3, "function", 0},                                      // add
+	{7, 17, 1, "variable", 0},                                      // l
+	{8, 2, 3, "property", modifierDeclaration},                     // fmt
+	{8, 8, 3, "namespace", modifierDefaultLibrary},                 // std
+	{8, 12, 6, "function", modifierDefaultLibrary},                 // format
+	{8, 20, 2, "formatSpecifier", 0},                               // %d, not the operands of == and in
+}
+
+func TestSemanticTokensFull(t *testing.T) {
+	s := testServer(t, nil)
+	uri := serverOpenTestFile(t, s, "./testdata/semantic-tokens.jsonnet")
+
+	result, err := s.SemanticTokensFull(context.Background(), &protocol.SemanticTokensParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "1", result.ResultID)
+	assert.Equal(t, semanticTokensTestFileTokens, decodeSemanticTokens(result.Data))
+}
+
+func TestSemanticTokensRange(t *testing.T) {
+	s := testServer(t, nil)
+	uri := serverOpenTestFile(t, s, "./testdata/semantic-tokens.jsonnet")
+
+	result, err := s.SemanticTokensRange(context.Background(), &protocol.SemanticTokensRangeParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+		Range: protocol.Range{
+			Start: protocol.Position{Line: 6, Character: 0},
+			End:   protocol.Position{Line: 7, Character: 12},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, semanticTokensTestFileTokens[25:28], decodeSemanticTokens(result.Data))
+}
+
+func TestSemanticTokensFullDelta(t *testing.T) {
+	s, uri := testServerWithFile(t, nil, "local a = 1;\n{ b: a }\n")
+
+	full, err := s.SemanticTokensFull(context.Background(), &protocol.SemanticTokensParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []decodedToken{
+		{0, 6, 1, "variable", modifierDeclaration},
+		{1, 2, 1, "property", modifierDeclaration},
+		{1, 5, 1, "variable", 0},
+	}, decodeSemanticTokens(full.Data))
+
+	err = s.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+		TextDocument: protocol.VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri},
+			Version:                2,
+		},
+		ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: "local a = 1;\n{ b: a, c: a }\n"}},
+	})
+	require.NoError(t, err)
+
+	// Unknown previous result, all tokens are returned
+	result, err := s.SemanticTokensFullDelta(context.Background(), &protocol.SemanticTokensDeltaParams{
+		TextDocument:     protocol.TextDocumentIdentifier{URI: uri},
+		PreviousResultID: "unknown",
+	})
+	require.NoError(t, err)
+	require.IsType(t, &protocol.SemanticTokens{}, result)
+	assert.Equal(t, "2", result.(*protocol.SemanticTokens).ResultID)
+
+	// Known previous result, only the new tokens are sent
+	err = s.DidChange(context.Background(), &protocol.DidChangeTextDocumentParams{
+		TextDocument: protocol.VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri},
+			Version:                3,
+		},
+		ContentChanges: []protocol.TextDocumentContentChangeEvent{{Text: "local a = 1;\n{ b: a, c: a, d: a }\n"}},
+	})
+	require.NoError(t, err)
+	result, err = s.SemanticTokensFullDelta(context.Background(), &protocol.SemanticTokensDeltaParams{
+		TextDocument:     protocol.TextDocumentIdentifier{URI: uri},
+		PreviousResultID: "2",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &protocol.SemanticTokensDelta{
+		ResultID: "3",
+		Edits: []protocol.SemanticTokensEdit{{
+			Start:       25,
+			DeleteCount: 0,
+			Data:        []uint32{0, 3, 1, tokenProperty, modifierDeclaration, 0, 3, 1, tokenVariable, 0},
+		}},
+	}, result)
+}
+
+func TestDiffSemanticTokens(t *testing.T) {
+	assert.Equal(t, []protocol.SemanticTokensEdit{}, diffSemanticTokens([]uint32{1, 2, 3}, []uint32{1, 2, 3}))
+	assert.Equal(t, []protocol.SemanticTokensEdit{{Start: 1, DeleteCount: 1, Data: []uint32{4, 5}}}, diffSemanticTokens([]uint32{1, 2, 3}, []uint32{1, 4, 5, 3}))
+	assert.Equal(t, []protocol.SemanticTokensEdit{{Start: 0, DeleteCount: 3, Data: []uint32{}}}, diffSemanticTokens([]uint32{1, 2, 3}, []uint32{}))
+}
@@ -19,8 +19,10 @@ package server
 import (
 	"context"
 	"path/filepath"
+	"sync"
 
 	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/formatter"
 	"github.com/grafana/jsonnet-language-server/pkg/stdlib"
 	"github.com/grafana/jsonnet-language-server/pkg/utils"
 	tankaJsonnet "github.com/grafana/tanka/pkg/jsonnet"
@@ -40,6 +42,9 @@ func NewServer(name, version string, client protocol.ClientCloser) *server {
 		version: version,
 		cache:   newCache(),
 		client:  client,
+		folderSettings: folderSettings{
+			fmtOpts: formatter.DefaultOptions(),
+		},
 	}
 
 	return server
@@ -49,46 +54,104 @@ func NewServer(name, version string, client protocol.ClientCloser) *server {
 type server struct {
 	name, version string
 
-	stdlib  []stdlib.Function
-	cache   *cache
-	client  protocol.ClientCloser
-	getVM   func(path string) (*jsonnet.VM, error)
-	extVars map[string]string
+	stdlib []stdlib.Function
+	cache  *cache
+	client protocol.ClientCloser
+	getVM  func(path string) (*jsonnet.VM, error)
+	// getJPaths returns the import paths used by the VM of the given file
+	getJPaths func(path string) []string
+	// getImporter returns the importer used by the VM of the given file
+	getImporter func(path string) jsonnet.Importer
+
+	// folderSettings are the settings from the command line. The client settings apply over a copy of them,
+	// see changeConfiguration
+	folderSettings
+	// globalSettings are the settings of the files outside of workspace folders with their own settings,
+	// nil until the client settings are applied. They are replaced, never modified, see folderSettingsFor
+	globalSettings *folderSettings
+	// folders are the workspace folders, see folderSettingsFor
+	folders   []*workspaceFolder
+	foldersMu sync.RWMutex
+
+	// rootPath is the root of the workspace, relative jpaths in the settings are resolved from it
+	rootPath string
+	// configurationPull is set if the client supports `workspace/configuration` requests
+	configurationPull bool
+	// registerInlayHints is set if the client supports the dynamic registration of inlay hints,
+	// see InlayHintCapabilitiesHandler
+	registerInlayHints bool
+	// watchFiles is set if the client supports the dynamic registration of `workspace/didChangeWatchedFiles`
+	watchFiles bool
 
-	// Feature flags
-	EvalDiags bool
-	LintDiags bool
+	// inlineEnvironments holds the name of the Tanka inline environment selected for each file
+	inlineEnvironments sync.Map
+	// formattingConfigs holds the modification time of each formatting config file when its diagnostics were
+	// published, see publishFormattingConfigDiags
+	formattingConfigs sync.Map
+
+	// Feature flags from the command line, they must not be modified once the server is started
+	EvalDiags       bool
+	FormatOnSave    bool
+	InlayHintValues bool
+	// flags are the feature flags applied from the client settings, nil until they are applied.
+	// Guarded by foldersMu, read them with featureFlags
+	flags *serverFlags
+	// defaultLogLevel is the log level from the command line, see changeConfiguration
+	defaultLogLevel     log.Level
+	defaultLogLevelOnce sync.Once
 }
 
 func (s *server) WithStaticVM(jpaths []string) *server {
 	log.Infof("Using the following jpaths: %v", jpaths)
-	s.getVM = func(path string) (*jsonnet.VM, error) {
-		jpaths = append(jpaths, filepath.Dir(path))
-		vm := jsonnet.MakeVM()
-		resetExtVars(vm, s.extVars)
-		importer := &jsonnet.FileImporter{JPaths: jpaths}
-		vm.Importer(importer)
-		return vm, nil
-	}
+	s.jpaths, s.tankaMode = jpaths, false
+	s.useFolderVMs()
 	return s
 }
 
 func (s *server) WithTankaVM(fallbackJPath []string) *server {
 	log.Infof("Using tanka mode. Will fall back to the following jpaths: %v", fallbackJPath)
-	s.getVM = func(path string) (*jsonnet.VM, error) {
-		jpath, _, _, err := jpath.Resolve(path)
-		if err != nil {
+	s.jpaths, s.tankaMode = fallbackJPath, true
+	s.useFolderVMs()
+	return s
+}
+
+// useFolderVMs sets the VM factory, which configures the VM of a file from the settings of its workspace folder.
+func (s *server) useFolderVMs() {
+	s.getJPaths = func(path string) []string {
+		settings := s.folderSettingsFor(path)
+		if settings.tankaMode {
+			jpath, _, _, err := jpath.Resolve(path)
+			if err == nil {
+				return jpath
+			}
 			log.Debugf("Unable to resolve jpath for %s: %s", path, err)
-			jpath = append(fallbackJPath, filepath.Dir(path))
 		}
-		opts := tankaJsonnet.Opts{
-			ImportPaths: jpath,
+		var jpaths []string
+		if project := findBundlerProject(path); project != nil {
+			jpaths = project.jpaths()
+		}
+		jpaths = append(jpaths, settings.jpaths...)
+		return append(jpaths, filepath.Dir(path))
+	}
+	s.getImporter = func(path string) jsonnet.Importer {
+		if s.folderSettingsFor(path).tankaMode {
+			return tankaJsonnet.NewExtendedImporter(s.getJPaths(path))
+		}
+		return &jsonnet.FileImporter{JPaths: s.getJPaths(path)}
+	}
+	s.getVM = func(path string) (*jsonnet.VM, error) {
+		settings := s.folderSettingsFor(path)
+		var vm *jsonnet.VM
+		if settings.tankaMode {
+			vm = tankaJsonnet.MakeVM(tankaJsonnet.Opts{ImportPaths: s.getJPaths(path)})
+		} else {
+			vm = jsonnet.MakeVM()
+			vm.Importer(s.getImporter(path))
 		}
-		vm := tankaJsonnet.MakeVM(opts)
-		resetExtVars(vm, s.extVars)
+		registerNativeFunctions(vm, settings)
+		resetEvalSettings(vm, s.evalSettingsFor(path))
 		return vm, nil
 	}
-	return s
 }
 
 func (s *server) DidChange(ctx context.Context, params *protocol.DidChangeTextDocumentParams) error {
@@ -101,6 +164,7 @@ func (s *server) DidChange(ctx context.Context, params *protocol.DidChangeTextDo
 
 	if params.TextDocument.Version > doc.item.Version && len(params.ContentChanges) != 0 {
 		doc.item.Text = params.ContentChanges[len(params.ContentChanges)-1].Text
+		doc.item.Version = params.TextDocument.Version
 		doc.ast, doc.err = jsonnet.SnippetToAST(doc.item.URI.SpanURI().Filename(), doc.item.Text)
 		if doc.err != nil {
 			return s.cache.put(doc)
@@ -136,16 +200,61 @@ func (s *server) Initialize(ctx context.Context, params *protocol.ParamInitializ
 		}
 	}
 
+	if params.RootURI != "" {
+		s.rootPath = params.RootURI.SpanURI().Filename()
+	}
+	s.configurationPull = params.Capabilities.Workspace.Configuration
+	s.watchFiles = params.Capabilities.Workspace.DidChangeWatchedFiles.DynamicRegistration
+	if len(params.WorkspaceFolders) > 0 {
+		s.addWorkspaceFolders(params.WorkspaceFolders)
+	} else if params.RootURI != "" {
+		// Clients without workspace folders have a single root
+		s.addWorkspaceFolders([]protocol.WorkspaceFolder{{URI: string(params.RootURI), Name: filepath.Base(s.rootPath)}})
+	}
+	if params.InitializationOptions != nil {
+		if err := s.changeConfiguration(ctx, params.InitializationOptions); err != nil {
+			return nil, err
+		}
+	}
+
 	return &protocol.InitializeResult{
 		Capabilities: protocol.ServerCapabilities{
-			CompletionProvider:         protocol.CompletionOptions{TriggerCharacters: []string{"."}},
-			HoverProvider:              true,
-			DefinitionProvider:         true,
-			DocumentFormattingProvider: true,
-			ExecuteCommandProvider:     protocol.ExecuteCommandOptions{Commands: []string{}},
+			CompletionProvider:              protocol.CompletionOptions{TriggerCharacters: []string{"."}},
+			CallHierarchyProvider:           true,
+			CodeLensProvider:                protocol.CodeLensOptions{ResolveProvider: true},
+			HoverProvider:                   true,
+			DefinitionProvider:              true,
+			DocumentHighlightProvider:       true,
+			DocumentFormattingProvider:      true,
+			DocumentRangeFormattingProvider: true,
+			DocumentOnTypeFormattingProvider: protocol.DocumentOnTypeFormattingOptions{
+				FirstTriggerCharacter: "}",
+				MoreTriggerCharacter:  []string{"]"},
+			},
+			ExecuteCommandProvider: protocol.ExecuteCommandOptions{Commands: commands},
+			Workspace: protocol.Workspace5Gn{
+				WorkspaceFolders: protocol.WorkspaceFolders4Gn{
+					Supported:           true,
+					ChangeNotifications: didChangeWorkspaceFoldersMethod,
+				},
+			},
+			DocumentLinkProvider:   protocol.DocumentLinkOptions{},
+			FoldingRangeProvider:   true,
+			SelectionRangeProvider: true,
+			SemanticTokensProvider: protocol.SemanticTokensOptions{
+				Legend: protocol.SemanticTokensLegend{
+					TokenTypes:     semanticTokenTypes,
+					TokenModifiers: semanticTokenModifiers,
+				},
+				Range: true,
+				Full: struct {
+					Delta bool `json:"delta"`
+				}{Delta: true},
+			},
 			TextDocumentSync: &protocol.TextDocumentSyncOptions{
-				Change:    protocol.Full,
-				OpenClose: true,
+				Change:            protocol.Full,
+				OpenClose:         true,
+				WillSaveWaitUntil: true,
 				Save: protocol.SaveOptions{
 					IncludeText: false,
 				},
@@ -160,3 +269,27 @@ func (s *server) Initialize(ctx context.Context, params *protocol.ParamInitializ
 		},
 	}, nil
 }
+
+// Initialized registers the capabilities that the protocol library cannot advertise in the initialize result,
+// loads the project config files and pulls the settings from clients that support it.
+func (s *server) Initialized(ctx context.Context, params *protocol.InitializedParams) error {
+	if s.registerInlayHints {
+		err := s.client.RegisterCapability(ctx, &protocol.RegistrationParams{
+			Registrations: []protocol.Registration{{ID: inlayHintMethod, Method: inlayHintMethod}},
+		})
+		if err != nil {
+			log.Warnf("Unable to register inlay hints: %v", err)
+		}
+	}
+	if s.watchFiles {
+		s.watchProjectConfigs(ctx)
+	}
+	for _, folder := range s.workspaceFolders() {
+		s.reloadProjectConfig(ctx, folder)
+	}
+
+	if s.configurationPull {
+		return s.pullConfiguration(ctx)
+	}
+	return nil
+}
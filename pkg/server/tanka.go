@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/grafana/jsonnet-language-server/pkg/utils"
+	"github.com/grafana/tanka/pkg/spec"
+	"github.com/grafana/tanka/pkg/spec/v1alpha1"
+	"github.com/grafana/tanka/pkg/tanka"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+const inlineEnvironmentsMethod = "tanka/inlineEnvironments"
+
+// tankaManifest identifies a Kubernetes object rendered by a Tanka environment.
+type tankaManifest struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace,omitempty"`
+}
+
+// tankaEnvironment describes a Tanka environment.
+type tankaEnvironment struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	APIServer string `json:"apiServer"`
+}
+
+// inlineEnvironmentFunction returns the Tanka inline environment with the given name from the value of a file.
+// Jsonnet is lazy, only the metadata of the other environments is evaluated.
+const inlineEnvironmentFunction = `function(main, name)
+  local find(object) =
+    if std.isObject(object) then
+      if std.objectHas(object, 'apiVersion') && std.objectHas(object, 'kind') then
+        if object.kind == 'Environment' && object.metadata.name == name then [object] else []
+      else std.flattenArrays([find(object[field]) for field in std.objectFields(object)])
+    else if std.isArray(object) then std.flattenArrays([find(element) for element in object])
+    else [];
+  local environments = find(main);
+  if std.length(environments) == 1 then environments[0]
+  else error 'found %d inline environments named %s' % [std.length(environments), name]`
+
+// findTankaEnvironment returns the environment defined by the spec.json next to the entrypoint of the file.
+// It returns nil if the file is not in a Tanka environment or if the environment has no spec.json.
+func findTankaEnvironment(filename string) *v1alpha1.Environment {
+	env, err := spec.ParseDir(filename)
+	if err != nil && !errors.As(err, &spec.ErrDeprecated{}) {
+		log.Debugf("Unable to find the Tanka environment of %s: %v", filename, err)
+		return nil
+	}
+	return env
+}
+
+// tankaEnvironmentHover describes the environment of a main.jsonnet file when hovering its top-level expression.
+func tankaEnvironmentHover(root, node ast.Node, filename string) *protocol.Hover {
+	if filepath.Base(filename) != "main.jsonnet" || node != topLevelExpression(root) {
+		return nil
+	}
+	env := findTankaEnvironment(filename)
+	if env == nil {
+		return nil
+	}
+
+	value := fmt.Sprintf("**Tanka environment** `%s`\n\n- Namespace: `%s`\n- API server: `%s`", env.Metadata.Name, env.Spec.Namespace, env.Spec.APIServer)
+	return &protocol.Hover{
+		Range:    position.RangeASTToProtocol(*node.Loc()),
+		Contents: protocol.MarkupContent{Kind: protocol.Markdown, Value: value},
+	}
+}
+
+// topLevelExpression returns the expression that a file evaluates to, after its locals.
+func topLevelExpression(root ast.Node) ast.Node {
+	for {
+		local, ok := root.(*ast.Local)
+		if !ok {
+			return root
+		}
+		root = local.Body
+	}
+}
+
+// showManifests renders the Tanka environment of a file and lists the Kubernetes objects it contains.
+// Arguments are the file and, optionally, the evaluation options of jsonnet.evalExpression.
+func (s *server) showManifests(ctx context.Context, params *protocol.ExecuteCommandParams) (interface{}, error) {
+	if len(params.Arguments) == 0 || len(params.Arguments) > 2 {
+		return nil, fmt.Errorf("expected 1 or 2 arguments, got %d", len(params.Arguments))
+	}
+
+	var fileName string
+	if err := json.Unmarshal(params.Arguments[0], &fileName); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal file name: %v", err)
+	}
+	var opts evalExpressionOptions
+	if len(params.Arguments) == 2 {
+		if err := json.Unmarshal(params.Arguments[1], &opts); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal evaluation options: %v", err)
+		}
+	}
+
+	settings := s.evalSettingsFor(fileName).merge(evalSettings{ExtVars: opts.ExtVars, ExtCode: opts.ExtCode, TLAStr: opts.TLAVars, TLACode: opts.TLACode})
+	// Tanka's options do not take native functions, the stubs of the settings are not available to the environment
+	result, err := tanka.Load(fileName, tanka.Opts{JsonnetOpts: tankaJsonnetOpts(settings), Name: opts.Environment})
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := make([]tankaManifest, 0, len(result.Resources))
+	for _, resource := range result.Resources {
+		manifests = append(manifests, tankaManifest{
+			APIVersion: resource.APIVersion(),
+			Kind:       resource.Kind(),
+			Name:       resource.Metadata().Name(),
+			Namespace:  resource.Metadata().Namespace(),
+		})
+	}
+	return manifests, nil
+}
+
+// tankaJsonnetOpts converts evaluation settings to Tanka's options, which only take code.
+func tankaJsonnetOpts(settings evalSettings) tanka.JsonnetOpts {
+	opts := tanka.JsonnetOpts{}
+	for name, value := range settings.ExtVars {
+		code, _ := json.Marshal(value)
+		opts.ExtCode.Set(name, string(code))
+	}
+	for name, code := range settings.ExtCode {
+		opts.ExtCode.Set(name, code)
+	}
+	for name, value := range settings.TLAStr {
+		code, _ := json.Marshal(value)
+		opts.TLACode.Set(name, string(code))
+	}
+	for name, code := range settings.TLACode {
+		opts.TLACode.Set(name, code)
+	}
+	return opts
+}
+
+// listInlineEnvironments returns the Tanka inline environments of the file saved on disk.
+func (s *server) listInlineEnvironments(filename string) ([]tankaEnvironment, error) {
+	if findTankaEnvironment(filename) != nil {
+		// Environments with a spec.json are not inline
+		return []tankaEnvironment{}, nil
+	}
+
+	envs, err := tanka.List(filename, tanka.Opts{JsonnetOpts: tankaJsonnetOpts(s.evalSettingsFor(filename))})
+	if err != nil {
+		return nil, err
+	}
+
+	environments := make([]tankaEnvironment, 0, len(envs))
+	for _, env := range envs {
+		environments = append(environments, tankaEnvironment{Name: env.Metadata.Name, Namespace: env.Spec.Namespace, APIServer: env.Spec.APIServer})
+	}
+	sort.Slice(environments, func(i, j int) bool { return environments[i].Name < environments[j].Name })
+	return environments, nil
+}
+
+// InlineEnvironmentsParams are the parameters of a `tanka/inlineEnvironments` request.
+type InlineEnvironmentsParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+}
+
+func (s *server) InlineEnvironments(ctx context.Context, params *InlineEnvironmentsParams) ([]tankaEnvironment, error) {
+	environments, err := s.listInlineEnvironments(params.TextDocument.URI.SpanURI().Filename())
+	if err != nil {
+		return nil, utils.LogErrorf("InlineEnvironments: %w", err)
+	}
+	return environments, nil
+}
+
+// selectEnvironment selects the Tanka inline environment that evaluation diagnostics of a file target.
+// Arguments are the file and the name of the environment. An empty name evaluates the whole file again.
+func (s *server) selectEnvironment(ctx context.Context, params *protocol.ExecuteCommandParams) (interface{}, error) {
+	if len(params.Arguments) != 2 {
+		return nil, fmt.Errorf("expected 2 arguments, got %d", len(params.Arguments))
+	}
+
+	var fileName, name string
+	if err := json.Unmarshal(params.Arguments[0], &fileName); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal file name: %v", err)
+	}
+	if err := json.Unmarshal(params.Arguments[1], &name); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal environment name: %v", err)
+	}
+
+	if name == "" {
+		s.inlineEnvironments.Delete(inlineEnvironmentKey(fileName))
+	} else {
+		s.inlineEnvironments.Store(inlineEnvironmentKey(fileName), name)
+	}
+	s.queueDiagnostics(protocol.URIFromPath(fileName))
+	return nil, nil
+}
+
+// selectedInlineEnvironment returns the name of the inline environment selected for a file, if any.
+func (s *server) selectedInlineEnvironment(filename string) string {
+	if name, ok := s.inlineEnvironments.Load(inlineEnvironmentKey(filename)); ok {
+		return name.(string)
+	}
+	return ""
+}
+
+// inlineEnvironmentKey normalizes the file names of the commands and of the documents, which differ
+// in their form, to the same key of inlineEnvironments.
+func inlineEnvironmentKey(filename string) string {
+	return protocol.URIFromPath(filename).SpanURI().Filename()
+}
+
+// inlineEnvironmentCode returns the code evaluating to the inline environment with the given name in the value of mainCode.
+func inlineEnvironmentCode(mainCode, name string) string {
+	quotedName, _ := json.Marshal(name)
+	return fmt.Sprintf("(%s)(%s, %s)", inlineEnvironmentFunction, mainCode, quotedName)
+}
+
+// documentImporter imports the unsaved text of a document instead of the file on disk.
+type documentImporter struct {
+	jsonnet.Importer
+	filename string
+	text     string
+}
+
+func (i *documentImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	if filepath.IsAbs(importedPath) && filepath.Clean(importedPath) == i.filename {
+		return jsonnet.MakeContents(i.text), i.filename, nil
+	}
+	return i.Importer.Import(importedFrom, importedPath)
+}
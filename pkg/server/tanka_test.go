@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grafana/jsonnet-language-server/pkg/position"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTankaEnvironmentHover(t *testing.T) {
+	s := testServer(t, nil)
+	uri := serverOpenTestFile(t, s, "./testdata/tanka/environments/default/main.jsonnet")
+
+	for _, tc := range []struct {
+		name     string
+		position protocol.Position
+		expected *protocol.Hover
+	}{
+		{
+			name:     "top-level object",
+			position: protocol.Position{Line: 1, Character: 0},
+			expected: &protocol.Hover{
+				Range: position.NewProtocolRange(1, 0, 3, 1),
+				Contents: protocol.MarkupContent{
+					Kind:  protocol.Markdown,
+					Value: "**Tanka environment** `environments/default`\n\n- Namespace: `default`\n- API server: `https://localhost:6443`",
+				},
+			},
+		},
+		{
+			name:     "field",
+			position: protocol.Position{Line: 2, Character: 10},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hover, err := s.Hover(context.Background(), &protocol.HoverParams{
+				TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+					TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+					Position:     tc.position,
+				},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, hover)
+		})
+	}
+}
+
+func TestTankaEnvironmentHoverOutsideEnvironment(t *testing.T) {
+	s := testServer(t, nil)
+	uri := serverOpenTestFile(t, s, "./testdata/tanka/lib/app.libsonnet")
+
+	hover, err := s.Hover(context.Background(), &protocol.HoverParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Position:     protocol.Position{Line: 0, Character: 0},
+		},
+	})
+	require.NoError(t, err)
+	assert.Nil(t, hover)
+}
+
+func TestShowManifests(t *testing.T) {
+	filename, err := filepath.Abs("./testdata/tanka/environments/default/main.jsonnet")
+	require.NoError(t, err)
+	fileArg, err := json.Marshal(filename)
+	require.NoError(t, err)
+
+	s := testServer(t, nil)
+	result, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+		Command:   "tanka.showManifests",
+		Arguments: []json.RawMessage{fileArg},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []tankaManifest{
+		{APIVersion: "v1", Kind: "ConfigMap", Name: "config", Namespace: "default"},
+	}, result)
+}
+
+func TestInlineEnvironments(t *testing.T) {
+	s := testServer(t, nil)
+
+	for _, tc := range []struct {
+		name     string
+		file     string
+		expected []tankaEnvironment
+	}{
+		{
+			name: "inline environments",
+			file: "./testdata/tanka/environments/inline/main.jsonnet",
+			expected: []tankaEnvironment{
+				{Name: "dev", Namespace: "dev", APIServer: "https://dev.example.com"},
+				{Name: "prod", Namespace: "prod", APIServer: "https://prod.example.com"},
+			},
+		},
+		{
+			name:     "environment with a spec.json",
+			file:     "./testdata/tanka/environments/default/main.jsonnet",
+			expected: []tankaEnvironment{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := s.NonstandardRequest(context.Background(), inlineEnvironmentsMethod, map[string]interface{}{
+				"textDocument": map[string]interface{}{"uri": absUri(t, tc.file)},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+func TestInlineEnvironmentEvaluation(t *testing.T) {
+	filename, err := filepath.Abs("./testdata/tanka/environments/inline/main.jsonnet")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name        string
+		command     string
+		args        []interface{}
+		expected    interface{}
+		expectedErr string
+	}{
+		{
+			name:     "expression",
+			command:  "jsonnet.evalExpression",
+			args:     []interface{}{filename, "main.data.config.data", map[string]string{"environment": "dev"}},
+			expected: "{\n   \"environment\": \"dev\"\n}\n",
+		},
+		{
+			name:        "file",
+			command:     "jsonnet.evalFile",
+			args:        []interface{}{filename, map[string]string{"environment": "prod"}},
+			expectedErr: "prod is broken",
+		},
+		{
+			name:        "unknown environment",
+			command:     "jsonnet.evalFile",
+			args:        []interface{}{filename, map[string]string{"environment": "staging"}},
+			expectedErr: "found 0 inline environments named staging",
+		},
+		{
+			name:     "manifests",
+			command:  "tanka.showManifests",
+			args:     []interface{}{filename, map[string]string{"environment": "dev"}},
+			expected: []tankaManifest{{APIVersion: "v1", Kind: "ConfigMap", Name: "dev", Namespace: "dev"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := testServer(t, nil).WithTankaVM(nil)
+
+			var args []json.RawMessage
+			for _, arg := range tc.args {
+				raw, err := json.Marshal(arg)
+				require.NoError(t, err)
+				args = append(args, raw)
+			}
+			result, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+				Command:   tc.command,
+				Arguments: args,
+			})
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+func TestInlineEnvironmentEvalDiags(t *testing.T) {
+	s := testServer(t, nil).WithTankaVM(nil)
+	s.EvalDiags = true
+	filename, err := filepath.Abs("./testdata/tanka/environments/inline/main.jsonnet")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		selected string
+		expected []string
+	}{
+		{name: "whole file", expected: []string{"prod is broken"}},
+		{name: "environment without errors", selected: "dev"},
+		{name: "environment with errors", selected: "prod", expected: []string{"prod is broken"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fileArg, _ := json.Marshal(filename)
+			nameArg, _ := json.Marshal(tc.selected)
+			_, err := s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+				Command:   "tanka.selectEnvironment",
+				Arguments: []json.RawMessage{fileArg, nameArg},
+			})
+			require.NoError(t, err)
+
+			// Evaluation errors are kept until the document changes
+			doc, err := s.cache.get(serverOpenTestFile(t, s, filename))
+			require.NoError(t, err)
+			var messages []string
+			for _, diag := range s.getEvalDiags(doc) {
+				assert.Equal(t, uint32(13), diag.Range.Start.Line, "errors are reported in the document")
+				messages = append(messages, strings.Split(diag.Message, "\n")[0])
+			}
+			assert.Len(t, messages, len(tc.expected))
+			for i, expected := range tc.expected {
+				assert.Contains(t, messages[i], expected)
+			}
+		})
+	}
+}
+
+func TestSelectEnvironmentFileName(t *testing.T) {
+	s := testServer(t, nil)
+	filename, err := filepath.Abs("./testdata/tanka/environments/inline/main.jsonnet")
+	require.NoError(t, err)
+
+	// The file name of the command is not in the form of the URIs of documents
+	fileArg, _ := json.Marshal("./testdata/tanka/environments/default/../inline/main.jsonnet")
+	_, err = s.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+		Command:   "tanka.selectEnvironment",
+		Arguments: []json.RawMessage{fileArg, json.RawMessage(`"dev"`)},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "dev", s.selectedInlineEnvironment(protocol.URIFromPath(filename).SpanURI().Filename()))
+}
@@ -0,0 +1 @@
+{ replicas: 3 }
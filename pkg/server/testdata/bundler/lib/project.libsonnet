@@ -0,0 +1 @@
+{ project: true }
@@ -0,0 +1,7 @@
+{
+  new(name):: {
+    name: name,
+    labels: $.withLabels(name),
+  },
+  withLabels(name):: { app: name },
+}
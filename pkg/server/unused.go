@@ -8,10 +8,6 @@ import (
 	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
 )
 
-func (s *server) Initialized(context.Context, *protocol.InitializedParams) error {
-	return nil
-}
-
 func (s *server) DocumentSymbol(ctx context.Context, params *protocol.DocumentSymbolParams) ([]interface{}, error) {
 	return nil, nil
 }
@@ -20,10 +16,6 @@ func (s *server) CodeAction(context.Context, *protocol.CodeActionParams) ([]prot
 	return nil, notImplemented("CodeAction")
 }
 
-func (s *server) CodeLens(ctx context.Context, params *protocol.CodeLensParams) ([]protocol.CodeLens, error) {
-	return []protocol.CodeLens{}, nil
-}
-
 func (s *server) CodeLensRefresh(context.Context) error {
 	return notImplemented("CodeLensRefresh")
 }
@@ -48,26 +40,14 @@ func (s *server) DocumentColor(context.Context, *protocol.DocumentColorParams) (
 	return nil, notImplemented("DocumentColor")
 }
 
-func (s *server) DocumentHighlight(context.Context, *protocol.DocumentHighlightParams) ([]protocol.DocumentHighlight, error) {
-	return nil, notImplemented("DocumentHighlight")
-}
-
 func (s *server) Exit(context.Context) error {
 	return notImplemented("Exit")
 }
 
-func (s *server) FoldingRange(context.Context, *protocol.FoldingRangeParams) ([]protocol.FoldingRange, error) {
-	return nil, notImplemented("FoldingRange")
-}
-
 func (s *server) Implementation(context.Context, *protocol.ImplementationParams) (protocol.Definition, error) {
 	return nil, notImplemented("Implementation")
 }
 
-func (s *server) IncomingCalls(context.Context, *protocol.CallHierarchyIncomingCallsParams) ([]protocol.CallHierarchyIncomingCall, error) {
-	return nil, notImplemented("IncomingCalls")
-}
-
 func (s *server) LinkedEditingRange(context.Context, *protocol.LinkedEditingRangeParams) (*protocol.LinkedEditingRanges, error) {
 	return nil, notImplemented("LinkedEditingRange")
 }
@@ -80,22 +60,6 @@ func (s *server) Moniker(context.Context, *protocol.MonikerParams) ([]protocol.M
 	return nil, notImplemented("Moniker")
 }
 
-func (s *server) NonstandardRequest(context.Context, string, interface{}) (interface{}, error) {
-	return nil, notImplemented("NonstandardRequest")
-}
-
-func (s *server) OnTypeFormatting(context.Context, *protocol.DocumentOnTypeFormattingParams) ([]protocol.TextEdit, error) {
-	return nil, notImplemented("OnTypeFormatting")
-}
-
-func (s *server) OutgoingCalls(context.Context, *protocol.CallHierarchyOutgoingCallsParams) ([]protocol.CallHierarchyOutgoingCall, error) {
-	return nil, notImplemented("OutgoingCalls")
-}
-
-func (s *server) PrepareCallHierarchy(context.Context, *protocol.CallHierarchyPrepareParams) ([]protocol.CallHierarchyItem, error) {
-	return nil, notImplemented("PrepareCallHierarchy")
-}
-
 func (s *server) PrepareRename(context.Context, *protocol.PrepareRenameParams) (*protocol.Range, error) {
 	return nil, notImplemented("PrepareRange")
 }
@@ -104,10 +68,6 @@ func (s *server) PrepareTypeHierarchy(context.Context, *protocol.TypeHierarchyPr
 	return nil, notImplemented("PrepareTypeHierarchy")
 }
 
-func (s *server) RangeFormatting(context.Context, *protocol.DocumentRangeFormattingParams) ([]protocol.TextEdit, error) {
-	return nil, notImplemented("RangeFormatting")
-}
-
 func (s *server) References(context.Context, *protocol.ReferenceParams) ([]protocol.Location, error) {
 	return nil, notImplemented("References")
 }
@@ -124,30 +84,10 @@ func (s *server) ResolveCodeAction(context.Context, *protocol.CodeAction) (*prot
 	return nil, notImplemented("ResolveCodeAction")
 }
 
-func (s *server) ResolveCodeLens(context.Context, *protocol.CodeLens) (*protocol.CodeLens, error) {
-	return nil, notImplemented("ResolveCodeLens")
-}
-
 func (s *server) ResolveDocumentLink(context.Context, *protocol.DocumentLink) (*protocol.DocumentLink, error) {
 	return nil, notImplemented("ResolveDocumentLink")
 }
 
-func (s *server) SelectionRange(context.Context, *protocol.SelectionRangeParams) ([]protocol.SelectionRange, error) {
-	return nil, notImplemented("SelectionRange")
-}
-
-func (s *server) SemanticTokensFull(context.Context, *protocol.SemanticTokensParams) (*protocol.SemanticTokens, error) {
-	return nil, notImplemented("SemanticTokensFull")
-}
-
-func (s *server) SemanticTokensFullDelta(context.Context, *protocol.SemanticTokensDeltaParams) (interface{}, error) {
-	return nil, notImplemented("SemanticTokensFullDelta")
-}
-
-func (s *server) SemanticTokensRange(context.Context, *protocol.SemanticTokensRangeParams) (*protocol.SemanticTokens, error) {
-	return nil, notImplemented("SemanticTokensRange")
-}
-
 func (s *server) SemanticTokensRefresh(context.Context) error {
 	return notImplemented("SemanticTokensRefresh")
 }
@@ -196,10 +136,6 @@ func (s *server) WillSave(context.Context, *protocol.WillSaveTextDocumentParams)
 	return notImplemented("WillSave")
 }
 
-func (s *server) WillSaveWaitUntil(context.Context, *protocol.WillSaveTextDocumentParams) ([]protocol.TextEdit, error) {
-	return nil, notImplemented("WillSaveWaitUntil")
-}
-
 func (s *server) WorkDoneProgressCancel(context.Context, *protocol.WorkDoneProgressCancelParams) error {
 	return notImplemented("WorkDoneProgressCancel")
 }
@@ -216,14 +152,6 @@ func (s *server) DiagnosticWorkspace(context.Context, *protocol.WorkspaceDiagnos
 	return nil, notImplemented("DiagnosticWorkspace")
 }
 
-func (s *server) DidChangeWatchedFiles(context.Context, *protocol.DidChangeWatchedFilesParams) error {
-	return notImplemented("DidChangeWatchedFiles")
-}
-
-func (s *server) DidChangeWorkspaceFolders(context.Context, *protocol.DidChangeWorkspaceFoldersParams) error {
-	return notImplemented("DidChangeWorkspaceFolders")
-}
-
 func (s *server) DidClose(context.Context, *protocol.DidCloseTextDocumentParams) error {
 	return notImplemented("DidClose")
 }
@@ -236,12 +164,6 @@ func (s *server) DidDeleteFiles(context.Context, *protocol.DeleteFilesParams) er
 	return notImplemented("DidDeleteFiles")
 }
 
-// DocumentLink is not implemented.
-// TODO(#13): Understand why the server capabilities includes documentlink.
-func (s *server) DocumentLink(context.Context, *protocol.DocumentLinkParams) ([]protocol.DocumentLink, error) {
-	return nil, nil
-}
-
 func notImplemented(method string) error {
 	return fmt.Errorf("%w: %q not yet implemented", jsonrpc2.ErrMethodNotFound, method)
 }
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"github.com/google/go-jsonnet/formatter"
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	didChangeWorkspaceFoldersMethod = "workspace/didChangeWorkspaceFolders"
+	didChangeWatchedFilesMethod     = "workspace/didChangeWatchedFiles"
+)
+
+// folderSettings are the settings that can differ between workspace folders.
+type folderSettings struct {
+	// jpaths and tankaMode configure the VM of files, see useFolderVMs
+	jpaths    []string
+	tankaMode bool
+	// tankaNatives registers Tanka's native functions outside of Tanka mode, see registerNativeFunctions
+	tankaNatives bool
+	nativeStubs  []nativeStub
+	LintDiags    bool
+	// lintRules enables or disables the lint rules, see lintRules
+	lintRules map[string]bool
+	fmtOpts   formatter.Options
+	// include and exclude select the files that are diagnosed, see diagnosed
+	include []glob.Glob
+	exclude []glob.Glob
+
+	evalSettings  evalSettings
+	evalOverrides []evalOverride
+}
+
+// clone returns a copy of the settings that can be modified without changing the original.
+func (f folderSettings) clone() folderSettings {
+	f.jpaths = append([]string{}, f.jpaths...)
+	f.evalSettings = f.evalSettings.merge(evalSettings{})
+	f.evalOverrides = append([]evalOverride{}, f.evalOverrides...)
+	f.nativeStubs = append([]nativeStub{}, f.nativeStubs...)
+	f.lintRules = copyBoolMap(f.lintRules)
+	f.include = append([]glob.Glob{}, f.include...)
+	f.exclude = append([]glob.Glob{}, f.exclude...)
+	return f
+}
+
+// diagnosed returns whether a file matches the include globs, if any, and none of the exclude globs.
+// Files that are not diagnosed are still navigable.
+func (f *folderSettings) diagnosed(path string) bool {
+	path = filepath.ToSlash(path)
+	for _, exclude := range f.exclude {
+		if exclude.Match(path) {
+			return false
+		}
+	}
+	for _, include := range f.include {
+		if include.Match(path) {
+			return true
+		}
+	}
+	return len(f.include) == 0
+}
+
+// workspaceFolder is a root folder of the workspace.
+type workspaceFolder struct {
+	name string
+	uri  string
+	path string
+	// rawSettings are the settings pulled for the folder, nil if the folder uses the global settings
+	rawSettings map[string]interface{}
+	// projectSettings are the settings of the project config file of the folder, see reloadProjectConfig
+	projectConfigPath string
+	projectSettings   map[string]interface{}
+	// settings are the global settings with rawSettings, then projectSettings, applied over them
+	settings *folderSettings
+}
+
+// folderSettingsFor returns the settings of the innermost workspace folder containing a file.
+// Files outside of workspace folders, or in folders without their own settings, use the global settings.
+// The returned settings must not be modified, changes of the settings replace them.
+func (s *server) folderSettingsFor(path string) *folderSettings {
+	s.foldersMu.RLock()
+	defer s.foldersMu.RUnlock()
+
+	var found *workspaceFolder
+	for _, folder := range s.folders {
+		if path != folder.path && !strings.HasPrefix(path, folder.path+string(filepath.Separator)) {
+			continue
+		}
+		if found == nil || len(folder.path) > len(found.path) {
+			found = folder
+		}
+	}
+	if found == nil || found.settings == nil {
+		return s.globalSettingsLocked()
+	}
+	return found.settings
+}
+
+// featureFlags returns the feature flags, the flags from the command line until the client settings are applied.
+func (s *server) featureFlags() serverFlags {
+	s.foldersMu.RLock()
+	defer s.foldersMu.RUnlock()
+
+	if s.flags == nil {
+		return serverFlags{evalDiags: s.EvalDiags, formatOnSave: s.FormatOnSave, inlayHintValues: s.InlayHintValues, logLevel: log.GetLevel()}
+	}
+	return *s.flags
+}
+
+// globalSettingsLocked returns the global settings, the settings from the command line until the client
+// settings are applied. foldersMu must be held.
+func (s *server) globalSettingsLocked() *folderSettings {
+	if s.globalSettings == nil {
+		return &s.folderSettings
+	}
+	return s.globalSettings
+}
+
+// workspaceFolders returns the current workspace folders.
+func (s *server) workspaceFolders() []*workspaceFolder {
+	s.foldersMu.RLock()
+	defer s.foldersMu.RUnlock()
+	return append([]*workspaceFolder{}, s.folders...)
+}
+
+// addWorkspaceFolders adds folders to the workspace and returns the added folders.
+// Folders already in the workspace are skipped.
+func (s *server) addWorkspaceFolders(folders []protocol.WorkspaceFolder) []*workspaceFolder {
+	s.foldersMu.Lock()
+	defer s.foldersMu.Unlock()
+
+	var added []*workspaceFolder
+outer:
+	for _, folder := range folders {
+		for _, existing := range s.folders {
+			if existing.uri == folder.URI {
+				continue outer
+			}
+		}
+		workspaceFolder := &workspaceFolder{
+			name: folder.Name,
+			uri:  folder.URI,
+			path: protocol.DocumentURI(folder.URI).SpanURI().Filename(),
+		}
+		log.Infof("Adding workspace folder %s", workspaceFolder.path)
+		s.folders = append(s.folders, workspaceFolder)
+		added = append(added, workspaceFolder)
+	}
+	return added
+}
+
+// removeWorkspaceFolders removes folders from the workspace.
+func (s *server) removeWorkspaceFolders(folders []protocol.WorkspaceFolder) {
+	s.foldersMu.Lock()
+	defer s.foldersMu.Unlock()
+
+	for _, folder := range folders {
+		for i, existing := range s.folders {
+			if existing.uri == folder.URI {
+				log.Infof("Removing workspace folder %s", existing.path)
+				s.folders = append(s.folders[:i], s.folders[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (s *server) DidChangeWorkspaceFolders(ctx context.Context, params *protocol.DidChangeWorkspaceFoldersParams) error {
+	s.removeWorkspaceFolders(params.Event.Removed)
+	added := s.addWorkspaceFolders(params.Event.Added)
+	for _, folder := range added {
+		s.reloadProjectConfig(ctx, folder)
+	}
+
+	if s.configurationPull && len(added) > 0 {
+		// The global settings are pulled again with the settings of the new folders
+		return s.pullConfiguration(ctx)
+	}
+	s.settingsChanged()
+	return nil
+}
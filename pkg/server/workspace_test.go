@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jdbaldry/go-language-server-protocol/lsp/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkspaceFolders(t *testing.T) {
+	root := t.TempDir()
+	folders := map[string]protocol.WorkspaceFolder{}
+	for _, name := range []string{"a", "b"} {
+		dir := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "lib"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "lib", "lib.libsonnet"), []byte(`{ name: '`+name+`' }`), 0o600))
+		folders[name] = protocol.WorkspaceFolder{URI: string(protocol.URIFromPath(dir)), Name: name}
+	}
+
+	client := &configurationClient{
+		settings: map[string]interface{}{"enable_lint_diagnostics": false},
+		folderSettings: map[string]interface{}{
+			folders["a"].URI: map[string]interface{}{
+				"jpath":                   []interface{}{"lib"},
+				"ext_vars":                map[string]interface{}{"folder": "a"},
+				"enable_lint_diagnostics": true,
+			},
+			folders["b"].URI: map[string]interface{}{
+				"jpath":    []interface{}{"lib"},
+				"ext_vars": map[string]interface{}{"folder": "b"},
+			},
+		},
+	}
+	s := NewServer("jsonnet-language-server", "dev", client).WithStaticVM([]string{})
+	params := &protocol.ParamInitialize{}
+	params.Capabilities.Workspace.Configuration = true
+	params.WorkspaceFolders = []protocol.WorkspaceFolder{folders["a"]}
+	result, err := s.Initialize(context.Background(), params)
+	require.NoError(t, err)
+	assert.True(t, result.Capabilities.Workspace.WorkspaceFolders.Supported)
+
+	require.NoError(t, s.Initialized(context.Background(), &protocol.InitializedParams{}))
+	require.Len(t, client.requests, 1)
+	assert.Equal(t, []protocol.ConfigurationItem{
+		{Section: "jsonnet"},
+		{ScopeURI: folders["a"].URI, Section: "jsonnet"},
+	}, client.requests[0].Items)
+
+	evaluate := func(folder string) (string, error) {
+		path := filepath.Join(root, folder, "main.jsonnet")
+		vm, err := s.getVM(path)
+		require.NoError(t, err)
+		return vm.EvaluateAnonymousSnippet(path, `(import 'lib.libsonnet').name + std.extVar('folder')`)
+	}
+
+	// Documents use the settings of the folder containing them
+	value, err := evaluate("a")
+	require.NoError(t, err)
+	assert.Equal(t, "\"aa\"\n", value)
+	assert.True(t, s.folderSettingsFor(filepath.Join(root, "a", "main.jsonnet")).LintDiags)
+	assert.False(t, s.folderSettingsFor(filepath.Join(root, "b", "main.jsonnet")).LintDiags)
+	_, err = evaluate("b")
+	assert.Error(t, err, "files outside of workspace folders use the global settings")
+
+	// Added folders pull their settings, removed folders use the global settings again
+	require.NoError(t, s.DidChangeWorkspaceFolders(context.Background(), &protocol.DidChangeWorkspaceFoldersParams{
+		Event: protocol.WorkspaceFoldersChangeEvent{
+			Added:   []protocol.WorkspaceFolder{folders["b"]},
+			Removed: []protocol.WorkspaceFolder{folders["a"]},
+		},
+	}))
+	value, err = evaluate("b")
+	require.NoError(t, err)
+	assert.Equal(t, "\"bb\"\n", value)
+	_, err = evaluate("a")
+	assert.Error(t, err)
+	assert.False(t, s.folderSettingsFor(filepath.Join(root, "a", "main.jsonnet")).LintDiags)
+
+	// Folder settings apply over the global settings
+	client.settings = map[string]interface{}{"enable_lint_diagnostics": true}
+	require.NoError(t, s.DidChangeConfiguration(context.Background(), &protocol.DidChangeConfigurationParams{}))
+	assert.True(t, s.folderSettingsFor(filepath.Join(root, "b", "main.jsonnet")).LintDiags)
+}
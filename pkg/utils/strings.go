@@ -11,3 +11,35 @@ func FirstWord(s string) string {
 	}
 	return words[0]
 }
+
+// LevenshteinDistance returns the number of single character edits needed to change a into b.
+func LevenshteinDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(first int, others ...int) int {
+	result := first
+	for _, i := range others {
+		if i < result {
+			result = i
+		}
+	}
+	return result
+}